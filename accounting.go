@@ -0,0 +1,485 @@
+package gridengine_prometheus
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+//Field positions within a line of the accounting file. See accounting(5)
+const (
+	accountingQueue      = 0
+	accountingHostname   = 1
+	accountingOwner      = 3
+	accountingJobNumber  = 5
+	accountingFailed     = 11
+	accountingExitStatus = 12
+	accountingWallclock  = 13
+	accountingProject    = 31
+	accountingSlots      = 34
+	accountingCPU        = 36
+	accountingPETaskID   = 41
+	accountingMaxVMem    = 42
+	//accountingMinFields is the fewest fields we can get a useful record from. pe_taskid and maxvmem are optional as
+	//older versions of grid engine don't write them
+	accountingMinFields = accountingCPU + 1
+)
+
+//DefaultAccountingInterval is how often the accounting file is checked for new records when no interval is given
+const DefaultAccountingInterval = 15 * time.Second
+
+//AccountingRecord is the subset of a finished job's accounting entry that we report on
+type AccountingRecord struct {
+	Queue      string
+	Hostname   string
+	Owner      string
+	JobNumber  int64
+	Failed     int
+	ExitStatus int
+	Wallclock  float64
+	Project    string
+	Slots      int
+	CPU        float64
+	//PETaskID is the task of a tightly integrated parallel job the record is for, or NONE for the job itself
+	PETaskID string
+	//MaxVMem is the maximum virtual memory used in bytes, if HasMaxVMem is set
+	MaxVMem    float64
+	HasMaxVMem bool
+}
+
+//IsPETask reports whether the record is for a task of a tightly integrated parallel job, which grid engine writes
+//alongside the record for the job itself
+func (r AccountingRecord) IsPETask() bool {
+	return len(r.PETaskID) > 0 && r.PETaskID != "NONE"
+}
+
+//ParseAccountingRecord parses a single colon separated line of the accounting file
+func ParseAccountingRecord(line string) (AccountingRecord, error) {
+	fields := strings.Split(line, ":")
+
+	if len(fields) < accountingMinFields {
+		return AccountingRecord{}, fmt.Errorf("accounting record has %d fields, expected at least %d", len(fields), accountingMinFields)
+	}
+
+	record := AccountingRecord{
+		Queue:    fields[accountingQueue],
+		Hostname: fields[accountingHostname],
+		Owner:    fields[accountingOwner],
+		Project:  fields[accountingProject],
+	}
+
+	var err error
+
+	if record.JobNumber, err = strconv.ParseInt(fields[accountingJobNumber], 10, 64); err != nil {
+		return record, fmt.Errorf("invalid job number: %w", err)
+	}
+
+	if record.Failed, err = strconv.Atoi(fields[accountingFailed]); err != nil {
+		return record, fmt.Errorf("invalid failed value: %w", err)
+	}
+
+	if record.ExitStatus, err = strconv.Atoi(fields[accountingExitStatus]); err != nil {
+		return record, fmt.Errorf("invalid exit status: %w", err)
+	}
+
+	if record.Wallclock, err = strconv.ParseFloat(fields[accountingWallclock], 64); err != nil {
+		return record, fmt.Errorf("invalid wallclock: %w", err)
+	}
+
+	if record.Slots, err = strconv.Atoi(fields[accountingSlots]); err != nil {
+		return record, fmt.Errorf("invalid slots: %w", err)
+	}
+
+	if record.CPU, err = strconv.ParseFloat(fields[accountingCPU], 64); err != nil {
+		return record, fmt.Errorf("invalid cpu: %w", err)
+	}
+
+	if len(fields) > accountingPETaskID {
+		record.PETaskID = fields[accountingPETaskID]
+	}
+
+	if len(fields) > accountingMaxVMem {
+		if record.MaxVMem, err = strconv.ParseFloat(fields[accountingMaxVMem], 64); err != nil {
+			return record, fmt.Errorf("invalid maxvmem: %w", err)
+		}
+		record.HasMaxVMem = true
+	}
+
+	return record, nil
+}
+
+//accountingState is what we persist between runs so that restarts pick up where we left off
+type accountingState struct {
+	Inode  uint64 `json:"inode"`
+	Offset int64  `json:"offset"`
+}
+
+//AccountingCollector incrementally tails the grid engine accounting file and reports on finished jobs
+type AccountingCollector struct {
+	Jobs        *prometheus.CounterVec
+	FailedJobs  *prometheus.CounterVec
+	CPU         *prometheus.CounterVec
+	Wallclock   *prometheus.HistogramVec
+	MaxVMem     *prometheus.HistogramVec
+	ParseErrors prometheus.Counter
+
+	//Path is the accounting file being tailed
+	Path string
+	//StatePath is where the current position in the accounting file is persisted. Empty disables persistence
+	StatePath string
+	//Interval is how often the accounting file is checked for new records
+	Interval time.Duration
+
+	mutex   sync.Mutex
+	file    *os.File
+	inode   uint64
+	offset  int64
+	partial string
+}
+
+//AccountingPath is the location of the accounting file for the provided SGE root and cell
+func AccountingPath(root, cell string) string {
+	return filepath.Join(root, cell, "common", "accounting")
+}
+
+//NewAccountingCollector returns a collector for the accounting file at path, with metric names prefixed by namespace.
+//It must be started to begin tailing
+func NewAccountingCollector(namespace string, path string, statePath string, interval time.Duration) *AccountingCollector {
+	recordLabels := []string{
+		"owner",
+		"project",
+		"queue",
+	}
+
+	return &AccountingCollector{
+		Jobs: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "accounting_jobs_total",
+			Help:      "Number of finished jobs recorded in the accounting file",
+		}, append(recordLabels, "exit_status")),
+		FailedJobs: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "accounting_failed_jobs_total",
+			Help:      "Number of finished jobs the accounting file reports grid engine failed to run",
+		}, recordLabels),
+		CPU: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "accounting_cpu_seconds_total",
+			Help:      "CPU time consumed by finished jobs",
+		}, recordLabels),
+		Wallclock: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "accounting_wallclock_seconds",
+			Help:      "Wall clock time of finished jobs",
+			Buckets:   prometheus.ExponentialBuckets(60, 4, 8),
+		}, recordLabels),
+		MaxVMem: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "accounting_maxvmem_bytes",
+			Help:      "Maximum virtual memory used by finished jobs",
+			Buckets:   prometheus.ExponentialBuckets(64*1024*1024, 2, 10),
+		}, recordLabels),
+		ParseErrors: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "accounting_parse_errors_total",
+			Help:      "Number of lines in the accounting file that could not be parsed",
+		}),
+		Path:      path,
+		StatePath: statePath,
+		Interval:  interval,
+	}
+}
+
+//Describe provides prometheus with descriptions and details (not values) of each metric
+func (collector *AccountingCollector) Describe(ch chan<- *prometheus.Desc) {
+	collector.Jobs.Describe(ch)
+	collector.FailedJobs.Describe(ch)
+	collector.CPU.Describe(ch)
+	collector.Wallclock.Describe(ch)
+	collector.MaxVMem.Describe(ch)
+	collector.ParseErrors.Describe(ch)
+}
+
+//Collect feeds the accumulated accounting metrics into the channel
+func (collector *AccountingCollector) Collect(ch chan<- prometheus.Metric) {
+	collector.Jobs.Collect(ch)
+	collector.FailedJobs.Collect(ch)
+	collector.CPU.Collect(ch)
+	collector.Wallclock.Collect(ch)
+	collector.MaxVMem.Collect(ch)
+	collector.ParseErrors.Collect(ch)
+}
+
+//Start polls the accounting file for new records on the configured interval until the context is done. An interval
+//that isn't positive polls every DefaultAccountingInterval
+func (collector *AccountingCollector) Start(ctx context.Context) {
+	interval := collector.Interval
+	if interval <= 0 {
+		interval = DefaultAccountingInterval
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		defer collector.Close()
+
+		for {
+			if err := collector.Poll(); err != nil {
+				log.WithError(err).Errorf("Unable to read new records from the accounting file %s", collector.Path)
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+//Poll reads any records appended since the last poll, following the file if it has been rotated or truncated
+func (collector *AccountingCollector) Poll() error {
+	collector.mutex.Lock()
+	defer collector.mutex.Unlock()
+
+	if collector.file == nil {
+		if err := collector.open(); err != nil {
+			return err
+		}
+	}
+
+	//Anything written to the old file before it was rotated still needs to be read before moving on
+	if err := collector.read(); err != nil {
+		return err
+	}
+
+	rotated, err := collector.rotated()
+	if err != nil {
+		return err
+	}
+
+	if rotated {
+		log.Infof("Accounting file %s has been rotated. Following the new file", collector.Path)
+		collector.closeFile()
+
+		if err := collector.openAt(0); err != nil {
+			return err
+		}
+
+		if err := collector.read(); err != nil {
+			return err
+		}
+	}
+
+	return collector.saveState()
+}
+
+//Close releases the accounting file
+func (collector *AccountingCollector) Close() {
+	collector.mutex.Lock()
+	defer collector.mutex.Unlock()
+	collector.closeFile()
+}
+
+func (collector *AccountingCollector) closeFile() {
+	if collector.file != nil {
+		_ = collector.file.Close()
+	}
+
+	collector.file = nil
+	collector.partial = ""
+}
+
+//open opens the accounting file for the first time, resuming from the persisted state if it refers to the same file.
+//Without any state we start at the end of the file rather than counting every job the cluster has ever run
+func (collector *AccountingCollector) open() error {
+	state, err := collector.loadState()
+	if err != nil {
+		log.WithError(err).Warn("Unable to load the accounting state. Starting from the end of the accounting file")
+	}
+
+	fi, err := os.Stat(collector.Path)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case state == nil:
+		return collector.openAt(fi.Size())
+	case state.Inode == inode(fi) && state.Offset <= fi.Size():
+		return collector.openAt(state.Offset)
+	default:
+		//The file was rotated while we weren't running. Whatever was left in the old file is lost
+		return collector.openAt(0)
+	}
+}
+
+func (collector *AccountingCollector) openAt(offset int64) error {
+	file, err := os.Open(collector.Path)
+	if err != nil {
+		return err
+	}
+
+	fi, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return err
+	}
+
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		_ = file.Close()
+		return err
+	}
+
+	collector.file = file
+	collector.inode = inode(fi)
+	collector.offset = offset
+	collector.partial = ""
+
+	return nil
+}
+
+//read processes every complete line from the current position to the end of the file. A trailing partial
+//line is held until the rest of it is written
+func (collector *AccountingCollector) read() error {
+	reader := bufio.NewReader(collector.file)
+
+	for {
+		line, err := reader.ReadString('\n')
+		collector.offset += int64(len(line))
+
+		if err == io.EOF {
+			collector.partial += line
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		line = collector.partial + line
+		collector.partial = ""
+
+		collector.process(strings.TrimRight(line, "\r\n"))
+	}
+}
+
+//rotated checks whether the path now refers to a different file, or the file we have open has been truncated
+func (collector *AccountingCollector) rotated() (bool, error) {
+	current, err := collector.file.Stat()
+	if err != nil {
+		return false, err
+	}
+
+	if current.Size() < collector.offset {
+		log.Infof("Accounting file %s has been truncated. Reading from the beginning", collector.Path)
+		if _, err := collector.file.Seek(0, io.SeekStart); err != nil {
+			return false, err
+		}
+		collector.offset = 0
+		collector.partial = ""
+		return false, collector.read()
+	}
+
+	latest, err := os.Stat(collector.Path)
+	if err != nil {
+		//Between moving the old file away and the new one being written there may be nothing there
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return !os.SameFile(current, latest), nil
+}
+
+func (collector *AccountingCollector) process(line string) {
+	if len(line) == 0 || strings.HasPrefix(line, "#") {
+		return
+	}
+
+	record, err := ParseAccountingRecord(line)
+	if err != nil {
+		log.WithError(err).Warn("Skipping unparseable accounting record")
+		collector.ParseErrors.Inc()
+		return
+	}
+
+	//The record for the job itself covers its parallel tasks, so counting theirs too would count the job again
+	if record.IsPETask() {
+		return
+	}
+
+	project := record.Project
+	exitStatus := strconv.Itoa(record.ExitStatus)
+
+	collector.Jobs.WithLabelValues(record.Owner, project, record.Queue, exitStatus).Inc()
+	collector.CPU.WithLabelValues(record.Owner, project, record.Queue).Add(record.CPU)
+	collector.Wallclock.WithLabelValues(record.Owner, project, record.Queue).Observe(record.Wallclock)
+
+	if record.HasMaxVMem {
+		collector.MaxVMem.WithLabelValues(record.Owner, project, record.Queue).Observe(record.MaxVMem)
+	}
+
+	if record.Failed != 0 {
+		collector.FailedJobs.WithLabelValues(record.Owner, project, record.Queue).Inc()
+	}
+}
+
+func (collector *AccountingCollector) loadState() (*accountingState, error) {
+	if len(collector.StatePath) == 0 {
+		return nil, nil
+	}
+
+	content, err := ioutil.ReadFile(collector.StatePath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	state := &accountingState{}
+	if err := json.Unmarshal(content, state); err != nil {
+		return nil, err
+	}
+
+	return state, nil
+}
+
+//saveState persists the offset of the last complete line read, writing to a temporary file first so a crash
+//never leaves a half written state behind
+func (collector *AccountingCollector) saveState() error {
+	if len(collector.StatePath) == 0 {
+		return nil
+	}
+
+	content, err := json.Marshal(accountingState{
+		Inode:  collector.inode,
+		Offset: collector.offset - int64(len(collector.partial)),
+	})
+
+	if err != nil {
+		return err
+	}
+
+	tmp := collector.StatePath + ".tmp"
+	if err := ioutil.WriteFile(tmp, content, 0644); err != nil {
+		return fmt.Errorf("unable to write accounting state: %w", err)
+	}
+
+	return os.Rename(tmp, collector.StatePath)
+}
@@ -0,0 +1,289 @@
+package gridengine_prometheus
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+)
+
+const accountingHeader = "# Version: 8.1.9\n# ATTENTION: This file contains the complete accounting information\n"
+
+func accountingLine(queue, owner, project string, job int, failed int, exitStatus int, wallclock int) string {
+	fields := make([]string, accountingMaxVMem+3)
+	for i := range fields {
+		fields[i] = "0"
+	}
+
+	fields[accountingQueue] = queue
+	fields[accountingHostname] = "ip-172-16-2-102.us-west-2.compute.internal"
+	fields[2] = "staff"
+	fields[accountingOwner] = owner
+	fields[4] = "Run"
+	fields[accountingJobNumber] = strconv.Itoa(job)
+	fields[6] = "sge"
+	fields[accountingFailed] = strconv.Itoa(failed)
+	fields[accountingExitStatus] = strconv.Itoa(exitStatus)
+	fields[accountingWallclock] = strconv.Itoa(wallclock)
+	fields[accountingProject] = project
+	fields[32] = "defaultdepartment"
+	fields[33] = "NONE"
+	fields[accountingSlots] = "1"
+	fields[accountingCPU] = "10.5"
+	fields[accountingPETaskID] = "NONE"
+	fields[accountingMaxVMem] = "1073741824"
+
+	return strings.Join(fields, ":") + "\n"
+}
+
+//withFields replaces fields of an accounting line, dropping any from keep onwards
+func withFields(line string, keep int, replace map[int]string) string {
+	fields := strings.Split(strings.TrimSpace(line), ":")
+
+	for field, value := range replace {
+		fields[field] = value
+	}
+
+	return strings.Join(fields[:keep], ":")
+}
+
+func appendFile(t *testing.T, path string, content string) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatalf("Unable to open %s: %s", path, err)
+	}
+	defer f.Close()
+
+	if _, err := f.WriteString(content); err != nil {
+		t.Fatalf("Unable to write to %s: %s", path, err)
+	}
+}
+
+func TestParseAccountingRecord(t *testing.T) {
+	tests := []struct {
+		name    string
+		line    string
+		want    AccountingRecord
+		wantErr bool
+	}{
+		{
+			name: "Complete record",
+			line: strings.TrimSpace(accountingLine("all.q", "jdoe", "modeling", 7, 0, 1, 120)),
+			want: AccountingRecord{
+				Queue:      "all.q",
+				Hostname:   "ip-172-16-2-102.us-west-2.compute.internal",
+				Owner:      "jdoe",
+				JobNumber:  7,
+				ExitStatus: 1,
+				Wallclock:  120,
+				Project:    "modeling",
+				Slots:      1,
+				CPU:        10.5,
+				PETaskID:   "NONE",
+				MaxVMem:    1073741824,
+				HasMaxVMem: true,
+			},
+		},
+		{
+			name: "Record without pe_taskid or maxvmem",
+			line: withFields(accountingLine("all.q", "jdoe", "modeling", 7, 0, 1, 120), accountingPETaskID, nil),
+			want: AccountingRecord{
+				Queue:      "all.q",
+				Hostname:   "ip-172-16-2-102.us-west-2.compute.internal",
+				Owner:      "jdoe",
+				JobNumber:  7,
+				ExitStatus: 1,
+				Wallclock:  120,
+				Project:    "modeling",
+				Slots:      1,
+				CPU:        10.5,
+			},
+		},
+		{
+			name:    "Truncated record",
+			line:    "all.q:ip-172-16-2-102:staff:jdoe",
+			wantErr: true,
+		},
+		{
+			name:    "Invalid exit status",
+			line:    strings.Replace(strings.TrimSpace(accountingLine("all.q", "jdoe", "NONE", 7, 0, 1, 120)), ":1:120:", ":x:120:", 1),
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseAccountingRecord(tt.line)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseAccountingRecord() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !tt.wantErr && got != tt.want {
+				t.Errorf("ParseAccountingRecord() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAccountingCollector_Poll(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "accounting")
+	state := filepath.Join(dir, "accounting.json")
+
+	//History from before the collector was ever started should not be counted
+	appendFile(t, path, accountingHeader+accountingLine("all.q", "jdoe", "NONE", 1, 0, 0, 60))
+
+	collector := NewAccountingCollector(DefaultNamespace, path, state, time.Minute)
+	defer collector.Close()
+
+	poll := func() {
+		if err := collector.Poll(); err != nil {
+			t.Fatalf("Poll() unexpected error = %v", err)
+		}
+	}
+
+	jobs := func(owner string, exitStatus string) float64 {
+		return testutil.ToFloat64(collector.Jobs.WithLabelValues(owner, "NONE", "all.q", exitStatus))
+	}
+
+	poll()
+	if got := jobs("jdoe", "0"); got != 0 {
+		t.Errorf("Existing records were counted: %v", got)
+	}
+
+	//New records, including one still being written
+	appendFile(t, path, accountingLine("all.q", "jdoe", "NONE", 2, 0, 0, 60)+accountingLine("all.q", "jdoe", "NONE", 3, 0, 1, 60))
+	partial := accountingLine("all.q", "asmith", "NONE", 4, 1, 0, 60)
+	appendFile(t, path, partial[:20])
+	poll()
+
+	if got := jobs("jdoe", "0"); got != 1 {
+		t.Errorf("jdoe exit 0 jobs = %v, want 1", got)
+	}
+	if got := jobs("jdoe", "1"); got != 1 {
+		t.Errorf("jdoe exit 1 jobs = %v, want 1", got)
+	}
+	if got := jobs("asmith", "0"); got != 0 {
+		t.Errorf("Partial record was counted")
+	}
+
+	appendFile(t, path, partial[20:])
+	poll()
+
+	if got := jobs("asmith", "0"); got != 1 {
+		t.Errorf("asmith jobs = %v, want 1 once the record was complete", got)
+	}
+	if got := testutil.ToFloat64(collector.FailedJobs.WithLabelValues("asmith", "NONE", "all.q")); got != 1 {
+		t.Errorf("asmith failed jobs = %v, want 1", got)
+	}
+
+	//Rotation: a record lands in the old file after the move, then the new file is created
+	if err := os.Rename(path, path+".0"); err != nil {
+		t.Fatal(err)
+	}
+	appendFile(t, path+".0", accountingLine("all.q", "jdoe", "NONE", 5, 0, 0, 60))
+	appendFile(t, path, accountingHeader+accountingLine("all.q", "jdoe", "NONE", 6, 0, 0, 60))
+	poll()
+
+	if got := jobs("jdoe", "0"); got != 3 {
+		t.Errorf("jdoe exit 0 jobs after rotation = %v, want 3", got)
+	}
+
+	//A restart resumes from the persisted offset rather than counting anything twice
+	collector.Close()
+	appendFile(t, path, accountingLine("all.q", "jdoe", "NONE", 7, 0, 0, 60))
+
+	restarted := NewAccountingCollector(DefaultNamespace, path, state, time.Minute)
+	defer restarted.Close()
+
+	if err := restarted.Poll(); err != nil {
+		t.Fatalf("Poll() unexpected error = %v", err)
+	}
+
+	if got := testutil.ToFloat64(restarted.Jobs.WithLabelValues("jdoe", "NONE", "all.q", "0")); got != 1 {
+		t.Errorf("jdoe jobs after restart = %v, want 1", got)
+	}
+
+	//Truncation in place starts over from the top of the file
+	if err := os.Truncate(path, 0); err != nil {
+		t.Fatal(err)
+	}
+	appendFile(t, path, accountingLine("all.q", "jdoe", "NONE", 8, 0, 0, 60))
+
+	if err := restarted.Poll(); err != nil {
+		t.Fatalf("Poll() unexpected error = %v", err)
+	}
+
+	if got := testutil.ToFloat64(restarted.Jobs.WithLabelValues("jdoe", "NONE", "all.q", "0")); got != 2 {
+		t.Errorf("jdoe jobs after truncation = %v, want 2", got)
+	}
+}
+
+func TestAccountingCollector_Process(t *testing.T) {
+	collector := NewAccountingCollector(DefaultNamespace, "", "", time.Minute)
+
+	job := accountingLine("all.q", "jdoe", "NONE", 1, 0, 0, 60)
+	collector.process(strings.TrimSpace(job))
+
+	//A task of a tightly integrated parallel job is covered by the record for the job
+	collector.process(withFields(job, accountingMaxVMem+1, map[int]string{accountingPETaskID: "1.node01"}))
+
+	//Older versions of grid engine don't write maxvmem
+	collector.process(withFields(accountingLine("all.q", "jdoe", "NONE", 2, 0, 0, 60), accountingMaxVMem, nil))
+
+	if got := testutil.ToFloat64(collector.Jobs.WithLabelValues("jdoe", "NONE", "all.q", "0")); got != 2 {
+		t.Errorf("jobs = %v, want 2", got)
+	}
+
+	if got := testutil.ToFloat64(collector.CPU.WithLabelValues("jdoe", "NONE", "all.q")); got != 21 {
+		t.Errorf("cpu seconds = %v, want 21", got)
+	}
+
+	observed := func(histogram *prometheus.HistogramVec) uint64 {
+		metric := &dto.Metric{}
+		if err := histogram.WithLabelValues("jdoe", "NONE", "all.q").(prometheus.Metric).Write(metric); err != nil {
+			t.Fatal(err)
+		}
+		return metric.GetHistogram().GetSampleCount()
+	}
+
+	if got := observed(collector.Wallclock); got != 2 {
+		t.Errorf("wallclock observations = %d, want 2", got)
+	}
+
+	if got := observed(collector.MaxVMem); got != 1 {
+		t.Errorf("maxvmem observations = %d, want 1 from the record that has it", got)
+	}
+}
+
+func TestAccountingCollector_StartWithoutInterval(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "accounting")
+	appendFile(t, path, accountingHeader)
+
+	//An interval of 0 falls back to the default rather than panicking in the ticker
+	collector := NewAccountingCollector(DefaultNamespace, path, "", 0)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	collector.Start(ctx)
+
+	for i := 0; i < 100; i++ {
+		collector.mutex.Lock()
+		opened := collector.file != nil
+		collector.mutex.Unlock()
+
+		if opened {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	t.Errorf("Start() never polled the accounting file")
+}
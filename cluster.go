@@ -0,0 +1,294 @@
+package gridengine_prometheus
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/sync/singleflight"
+)
+
+//ErrCommandTimeout is returned when a grid engine command runs for longer than the timeout of its cluster
+var ErrCommandTimeout = errors.New("command timed out")
+
+//commandWaitDelay is how long the output of a killed command is waited on. Anything it started that is still holding
+//on to its output is given up on after this
+const commandWaitDelay = time.Second
+
+//Cluster is a grid engine cell and everything needed to run its commands. Each command is given the environment of
+//the cell rather than it being set on the exporter, so a single exporter can report on several cells at once. The zero
+//value runs commands with the environment the exporter was started with
+type Cluster struct {
+	//Name identifies the cluster in the cluster label
+	Name        string
+	Arch        string
+	Cell        string
+	Root        string
+	ExecdPort   int
+	QmasterPort int
+	ClusterName string
+	//Timeout is how long a command may run before it is killed, along with anything it started. 0 waits forever
+	Timeout time.Duration
+}
+
+//BinPaths are the directories the grid engine commands of the cluster are found in
+func (c Cluster) BinPaths() []string {
+	if len(c.Root) == 0 {
+		return nil
+	}
+
+	bin := filepath.Join(c.Root, "bin")
+
+	if len(c.Arch) == 0 {
+		return []string{bin}
+	}
+
+	return []string{filepath.Join(bin, c.Arch), bin}
+}
+
+//Environ is the environment of the exporter with the SGE variables of the cluster set, and its commands added to the
+//end of the PATH
+func (c Cluster) Environ() []string {
+	settings := map[string]string{
+		"SGE_ARCH":         c.Arch,
+		"SGE_CELL":         c.Cell,
+		"SGE_ROOT":         c.Root,
+		"SGE_CLUSTER_NAME": c.ClusterName,
+	}
+
+	if c.ExecdPort > 0 {
+		settings["SGE_EXECD_PORT"] = strconv.Itoa(c.ExecdPort)
+	}
+
+	if c.QmasterPort > 0 {
+		settings["SGE_QMASTER_PORT"] = strconv.Itoa(c.QmasterPort)
+	}
+
+	env := make([]string, 0)
+
+	for _, v := range os.Environ() {
+		key := strings.SplitN(v, "=", 2)[0]
+
+		if value, ok := settings[key]; ok && len(value) > 0 {
+			continue
+		}
+
+		if key == "PATH" {
+			continue
+		}
+
+		env = append(env, v)
+	}
+
+	for _, key := range []string{"SGE_ARCH", "SGE_CELL", "SGE_ROOT", "SGE_CLUSTER_NAME", "SGE_EXECD_PORT", "SGE_QMASTER_PORT"} {
+		if value := settings[key]; len(value) > 0 {
+			env = append(env, key+"="+value)
+		}
+	}
+
+	path := filepath.SplitList(os.Getenv("PATH"))
+	path = append(path, c.BinPaths()...)
+
+	return append(env, "PATH="+strings.Join(path, string(os.PathListSeparator)))
+}
+
+//Command returns the command for the named grid engine binary of the cluster, ready to run in the environment of the
+//cluster. Names that aren't a path are looked for in the binaries of the cluster before the PATH of the exporter
+func (c Cluster) Command(name string, args ...string) *exec.Cmd {
+	return c.CommandContext(context.Background(), name, args...)
+}
+
+//CommandContext is Command, killing the command and every process in its process group once the context is done. A
+//hung qmaster can leave the grid engine commands waiting on it indefinitely, and on anything they started
+func (c Cluster) CommandContext(ctx context.Context, name string, args ...string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, c.lookPath(name), args...)
+
+	if c != (Cluster{}) {
+		cmd.Env = c.Environ()
+	}
+
+	killProcessGroup(cmd)
+	cmd.WaitDelay = commandWaitDelay
+
+	return cmd
+}
+
+//Output runs the named grid engine binary of the cluster and returns what it wrote to stdout, killing it if it runs for
+//longer than the timeout of the cluster
+func (c Cluster) Output(name string, args ...string) (string, error) {
+	stream, err := c.Stream(name, args...)
+	if err != nil {
+		return "", err
+	}
+
+	out, err := io.ReadAll(stream)
+
+	if closeErr := stream.Close(); closeErr != nil {
+		return string(out), closeErr
+	}
+
+	return string(out), err
+}
+
+//Stream starts the named grid engine binary of the cluster and returns its stdout as it is written. The command is
+//killed if it runs for longer than the timeout of the cluster. Closing the stream waits for the command to exit and
+//returns any failure to run it
+func (c Cluster) Stream(name string, args ...string) (io.ReadCloser, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	if c.Timeout > 0 {
+		var cancelTimeout context.CancelFunc
+		ctx, cancelTimeout = context.WithTimeout(ctx, c.Timeout)
+
+		cancelStream := cancel
+		cancel = func() {
+			cancelTimeout()
+			cancelStream()
+		}
+	}
+
+	cmd := c.CommandContext(ctx, name, args...)
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	if err := cmd.Start(); err != nil {
+		cancel()
+		return nil, err
+	}
+
+	return &commandStream{
+		stdout:  stdout,
+		cmd:     cmd,
+		ctx:     ctx,
+		cancel:  cancel,
+		name:    name,
+		timeout: c.Timeout,
+	}, nil
+}
+
+//CommandRunner runs the grid engine commands of a cluster other than the qstat polled for snapshots, such as qhost or
+//qquota. A command that is already running is waited on and its output shared, rather than every scrape that overlaps
+//it starting one of its own. A command that fails to run is run again up to Retries more times, waiting Backoff before
+//the first retry and twice as long before each one after
+type CommandRunner struct {
+	Cluster Cluster
+	Retries int
+	Backoff time.Duration
+
+	//runs holds the run of each command in flight, keyed by commandKey
+	runs singleflight.Group
+}
+
+//NewCommandRunner returns a runner for the commands of the cluster
+func NewCommandRunner(cluster Cluster, retries int, backoff time.Duration) *CommandRunner {
+	return &CommandRunner{
+		Cluster: cluster,
+		Retries: retries,
+		Backoff: backoff,
+	}
+}
+
+//commandKey identifies a command along with its arguments
+func commandKey(name string, args ...string) string {
+	return strings.Join(append([]string{name}, args...), "\x00")
+}
+
+//Output runs the named grid engine binary of the cluster and returns what it wrote to stdout, or shares the output of
+//the run already in flight. A nil runner runs it once with the environment the exporter was started with
+func (r *CommandRunner) Output(name string, args ...string) (string, error) {
+	if r == nil {
+		return Cluster{}.Output(name, args...)
+	}
+
+	out, err, _ := r.runs.Do(commandKey(name, args...), func() (interface{}, error) {
+		return r.output(name, args...)
+	})
+
+	return out.(string), err
+}
+
+func (r *CommandRunner) output(name string, args ...string) (string, error) {
+	backoff := r.Backoff
+
+	for attempt := 0; ; attempt++ {
+		out, err := r.Cluster.Output(name, args...)
+		if err == nil || attempt >= r.Retries {
+			return out, err
+		}
+
+		log.WithError(err).Warnf("Unable to run %s. Trying again in %s", name, backoff)
+		time.Sleep(backoff)
+
+		backoff *= 2
+	}
+}
+
+//commandStream is the stdout of a running command
+type commandStream struct {
+	stdout   io.ReadCloser
+	cmd      *exec.Cmd
+	ctx      context.Context
+	cancel   context.CancelFunc
+	name     string
+	timeout  time.Duration
+	finished bool
+}
+
+func (s *commandStream) Read(p []byte) (int, error) {
+	n, err := s.stdout.Read(p)
+	if err == io.EOF {
+		s.finished = true
+	}
+	return n, err
+}
+
+//Close waits for the command to exit. A command that hasn't been read to the end is killed first, as nothing is going
+//to read the rest of its output, and that is not counted as a failure
+func (s *commandStream) Close() error {
+	if !s.finished {
+		s.cancel()
+	}
+
+	err := s.cmd.Wait()
+	timedOut := errors.Is(s.ctx.Err(), context.DeadlineExceeded)
+	s.cancel()
+
+	if timedOut {
+		return fmt.Errorf("%w: %s ran for longer than %s", ErrCommandTimeout, s.name, s.timeout)
+	}
+
+	if !s.finished {
+		return nil
+	}
+
+	return err
+}
+
+//lookPath finds name amongst the binaries of the cluster. exec.Command only searches the PATH of the exporter itself,
+//which no longer has the grid engine binaries on it
+func (c Cluster) lookPath(name string) string {
+	if strings.ContainsRune(name, os.PathSeparator) {
+		return name
+	}
+
+	for _, dir := range c.BinPaths() {
+		path := filepath.Join(dir, name)
+
+		if info, err := os.Stat(path); err == nil && !info.IsDir() && info.Mode()&0111 != 0 {
+			return path
+		}
+	}
+
+	return name
+}
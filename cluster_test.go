@@ -0,0 +1,340 @@
+package gridengine_prometheus
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+//writeCommand writes a shell script into dir that prints the SGE environment it was run with
+func writeCommand(t *testing.T, dir string, name string) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	script := "#!/bin/sh\necho $SGE_ROOT $SGE_CELL $SGE_QMASTER_PORT $SGE_CLUSTER_NAME $@\n"
+	if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCluster_Command(t *testing.T) {
+	east := t.TempDir()
+	west := t.TempDir()
+
+	writeCommand(t, filepath.Join(east, "bin", "lx-amd64"), "qstat")
+	writeCommand(t, filepath.Join(west, "bin"), "qstat")
+
+	//Commands run for one cluster must never leak into the environment of the exporter or the other clusters
+	t.Setenv("SGE_CELL", "exporter")
+
+	tests := []struct {
+		name    string
+		cluster Cluster
+		want    string
+	}{
+		{
+			name: "Arch specific binaries",
+			cluster: Cluster{
+				Name:        "east",
+				Arch:        "lx-amd64",
+				Cell:        "default",
+				Root:        east,
+				QmasterPort: 6444,
+				ClusterName: "p6444",
+			},
+			want: east + " default 6444 p6444 -xml",
+		},
+		{
+			name: "Binaries in the root bin",
+			cluster: Cluster{
+				Name:        "west",
+				Arch:        "lx-arm64",
+				Cell:        "west",
+				Root:        west,
+				QmasterPort: 7444,
+				ClusterName: "p7444",
+			},
+			want: west + " west 7444 p7444 -xml",
+		},
+		{
+			name: "Cell inherited from the exporter",
+			cluster: Cluster{
+				Root:        west,
+				QmasterPort: 7444,
+				ClusterName: "p7444",
+			},
+			want: west + " exporter 7444 p7444 -xml",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := tt.cluster.Command("qstat", "-xml").Output()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := strings.TrimSpace(string(out)); got != tt.want {
+				t.Errorf("Command() output = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	if os.Getenv("SGE_ROOT") == east || os.Getenv("SGE_ROOT") == west {
+		t.Errorf("SGE_ROOT of a cluster leaked into the exporter")
+	}
+}
+
+func TestCluster_Environ(t *testing.T) {
+	cluster := Cluster{
+		Arch:        "lx-amd64",
+		Cell:        "default",
+		Root:        "/opt/sge",
+		ExecdPort:   6445,
+		QmasterPort: 6444,
+		ClusterName: "p6444",
+	}
+
+	env := make(map[string]string)
+	for _, v := range cluster.Environ() {
+		parts := strings.SplitN(v, "=", 2)
+		env[parts[0]] = parts[1]
+	}
+
+	want := map[string]string{
+		"SGE_ARCH":         "lx-amd64",
+		"SGE_CELL":         "default",
+		"SGE_ROOT":         "/opt/sge",
+		"SGE_EXECD_PORT":   "6445",
+		"SGE_QMASTER_PORT": "6444",
+		"SGE_CLUSTER_NAME": "p6444",
+	}
+
+	for key, value := range want {
+		if env[key] != value {
+			t.Errorf("Environ() %s = %q, want %q", key, env[key], value)
+		}
+	}
+
+	if !strings.HasSuffix(env["PATH"], ":/opt/sge/bin/lx-amd64:/opt/sge/bin") {
+		t.Errorf("Environ() PATH = %q, want the SGE binaries on the end", env["PATH"])
+	}
+}
+
+func TestCluster_Output(t *testing.T) {
+	root := t.TempDir()
+	bin := filepath.Join(root, "bin")
+	writeCommand(t, bin, "qstat")
+
+	//A hung qstat that has started a child of its own, which holds on to its output
+	pidFile := filepath.Join(root, "child.pid")
+	hung := "#!/bin/sh\nsleep 60 &\necho $! > " + pidFile + "\nwait\n"
+	if err := ioutil.WriteFile(filepath.Join(bin, "qhost"), []byte(hung), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	cluster := Cluster{
+		Root:        root,
+		Cell:        "default",
+		QmasterPort: 6444,
+		ClusterName: "p6444",
+		Timeout:     200 * time.Millisecond,
+	}
+
+	out, err := cluster.Output("qstat", "-xml")
+	if err != nil || strings.TrimSpace(out) != root+" default 6444 p6444 -xml" {
+		t.Errorf("Output() = %q, %v", out, err)
+	}
+
+	start := time.Now()
+
+	if _, err := cluster.Output("qhost", "-xml"); !errors.Is(err, ErrCommandTimeout) {
+		t.Errorf("Output() error = %v, want %v", err, ErrCommandTimeout)
+	}
+
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Output() took %s to give up on a hung command", elapsed)
+	}
+
+	pid, err := ioutil.ReadFile(pidFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//The child is killed along with qstat. It may linger as a zombie until it is reaped, but can't still be running
+	stat := filepath.Join("/proc", strings.TrimSpace(string(pid)), "stat")
+	for i := 0; i < 50; i++ {
+		content, err := ioutil.ReadFile(stat)
+		if err != nil || strings.Contains(string(content), ") Z ") {
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	if child, err := strconv.Atoi(strings.TrimSpace(string(pid))); err == nil {
+		if process, err := os.FindProcess(child); err == nil {
+			process.Kill()
+		}
+	}
+	t.Errorf("The child of the hung command was left running")
+}
+
+func TestCluster_Stream(t *testing.T) {
+	root := t.TempDir()
+	bin := filepath.Join(root, "bin")
+
+	commands := map[string]string{
+		//Fails after writing part of its output
+		"qstat": "#!/bin/sh\necho '<job_info>'\nexit 1\n",
+		//Writes more than anyone will read
+		"qhost": "#!/bin/sh\nwhile true; do echo '<host/>'; done\n",
+	}
+
+	for name, script := range commands {
+		if err := os.MkdirAll(bin, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(bin, name), []byte(script), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cluster := Cluster{
+		Root:    root,
+		Timeout: 5 * time.Second,
+	}
+
+	stream, err := cluster.Stream("qstat")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := ioutil.ReadAll(stream)
+	if err != nil || strings.TrimSpace(string(out)) != "<job_info>" {
+		t.Errorf("Read() = %q, %v", out, err)
+	}
+
+	if err := stream.Close(); err == nil {
+		t.Errorf("Close() of a command that failed returned no error")
+	}
+
+	stream, err = cluster.Stream("qhost")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := stream.Read(make([]byte, 16)); err != nil {
+		t.Errorf("Read() error = %v", err)
+	}
+
+	//Closing part way through kills the command rather than waiting for it, and isn't a failure
+	start := time.Now()
+
+	if err := stream.Close(); err != nil {
+		t.Errorf("Close() of an abandoned stream = %v", err)
+	}
+
+	if elapsed := time.Since(start); elapsed > 3*time.Second {
+		t.Errorf("Close() took %s to give up on an abandoned stream", elapsed)
+	}
+}
+
+func TestCommandRunner_Output(t *testing.T) {
+	root := t.TempDir()
+	bin := filepath.Join(root, "bin")
+	runs := filepath.Join(root, "runs")
+	release := filepath.Join(root, "release")
+
+	commands := map[string]string{
+		//Fails the first two times it is run
+		"qquota": "#!/bin/sh\necho run >> " + runs + "\n[ $(wc -l < " + runs + ") -gt 2 ] || exit 1\necho '<qquota_result/>'\n",
+		//Waits to be released, so runs of it overlap
+		"qhost": "#!/bin/sh\necho run >> " + runs + "\nwhile [ ! -f " + release + " ]; do sleep 0.01; done\necho '<qhost/>'\n",
+	}
+
+	if err := os.MkdirAll(bin, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for name, script := range commands {
+		if err := ioutil.WriteFile(filepath.Join(bin, name), []byte(script), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	count := func() int {
+		content, _ := ioutil.ReadFile(runs)
+		return strings.Count(string(content), "run")
+	}
+
+	t.Run("Retries a command that fails to run", func(t *testing.T) {
+		os.Remove(runs)
+
+		runner := NewCommandRunner(Cluster{Root: root, Timeout: 5 * time.Second}, 2, time.Millisecond)
+
+		out, err := runner.Output("qquota", "-xml")
+		if err != nil || strings.TrimSpace(out) != "<qquota_result/>" {
+			t.Errorf("Output() = %q, %v", out, err)
+		}
+
+		if got := count(); got != 3 {
+			t.Errorf("qquota was run %d times, want 3", got)
+		}
+
+		os.Remove(runs)
+		runner.Retries = 1
+
+		if _, err := runner.Output("qquota", "-xml"); err == nil {
+			t.Errorf("Output() of a command that failed every retry returned no error")
+		}
+	})
+
+	t.Run("Shares a run in flight", func(t *testing.T) {
+		os.Remove(runs)
+
+		runner := NewCommandRunner(Cluster{Root: root, Timeout: 5 * time.Second}, 0, 0)
+
+		first := make(chan string)
+		go func() {
+			out, _ := runner.Output("qhost", "-xml")
+			first <- out
+		}()
+
+		for i := 0; count() == 0; i++ {
+			if i > 500 {
+				t.Fatal("qhost was never run")
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		//qhost is running, so anything asking for it now waits on that run
+		waiting := make([]<-chan singleflight.Result, 0, 3)
+		for i := 0; i < 3; i++ {
+			waiting = append(waiting, runner.runs.DoChan(commandKey("qhost", "-xml"), func() (interface{}, error) {
+				t.Error("qhost was run again while a run was in flight")
+				return "", nil
+			}))
+		}
+
+		if err := ioutil.WriteFile(release, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		want := <-first
+		for _, w := range waiting {
+			if result := <-w; !result.Shared || result.Val.(string) != want {
+				t.Errorf("Waiting on the run in flight = %+v, want %q shared", result, want)
+			}
+		}
+
+		if got := count(); got != 1 {
+			t.Errorf("qhost was run %d times, want 1", got)
+		}
+	})
+}
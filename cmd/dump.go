@@ -0,0 +1,80 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var dumpCmd = &cobra.Command{
+	Use:   "dump",
+	Short: "Write the metrics once and exit",
+	Long: "Run the collectors once and write the metrics in the Prometheus text format to stdout, or to a file for the " +
+		"node_exporter textfile collector. The file is replaced atomically so it is never read half written",
+	Example: `gridengine_prometheus dump --config /etc/gridengine_prometheus.yaml --output /var/lib/node_exporter/sge.prom`,
+	RunE:    dump,
+}
+
+func dump(cmd *cobra.Command, args []string) error {
+	config, err := loadConfig()
+	if err != nil {
+		return err
+	}
+
+	//There is no background polling to wait on, so qstat is run as the metrics are gathered
+	config.PollInterval = 0
+
+	//Accounting counters start from zero on every run, so a single run has nothing useful to say about finished jobs
+	if config.Accounting {
+		log.Warn("Accounting metrics aren't available from dump. Run the exporter as a server to report finished jobs")
+		config.Accounting = false
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	output, _ := cmd.Flags().GetString("output")
+
+	//The Go and process metrics of the exporter are left out as they would clash with those of node_exporter
+	registry, err := newGridEngineRegistry(ctx, config)
+	if err != nil {
+		return err
+	}
+
+	return writeMetrics(registry, output, os.Stdout)
+}
+
+//writeMetrics gathers the metrics and writes them in the text format to the output file, or to w if there isn't one
+func writeMetrics(gatherer prometheus.Gatherer, output string, w io.Writer) error {
+	if len(output) > 0 {
+		if err := prometheus.WriteToTextfile(output, gatherer); err != nil {
+			return fmt.Errorf("unable to write metrics to %s: %w", output, err)
+		}
+
+		return nil
+	}
+
+	families, err := gatherer.Gather()
+	if err != nil {
+		return fmt.Errorf("unable to gather metrics: %w", err)
+	}
+
+	for _, family := range families {
+		if _, err := expfmt.MetricFamilyToText(w, family); err != nil {
+			return fmt.Errorf("unable to write metrics: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func init() {
+	dumpCmd.Flags().StringP("output", "o", "", "File to write the metrics to instead of stdout. Should end in .prom for the node_exporter textfile collector")
+	RootCmd.AddCommand(dumpCmd)
+}
@@ -0,0 +1,68 @@
+package cmd
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestWriteMetrics(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	gauge := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "sge_up",
+		Help: "Test gauge",
+	})
+	gauge.Set(1)
+	registry.MustRegister(gauge)
+
+	want := "# HELP sge_up Test gauge\n# TYPE sge_up gauge\nsge_up 1\n"
+
+	t.Run("Stdout", func(t *testing.T) {
+		out := &bytes.Buffer{}
+		if err := writeMetrics(registry, "", out); err != nil {
+			t.Fatal(err)
+		}
+
+		if out.String() != want {
+			t.Errorf("writeMetrics() wrote %q, want %q", out.String(), want)
+		}
+	})
+
+	t.Run("File", func(t *testing.T) {
+		dir := t.TempDir()
+		output := filepath.Join(dir, "sge.prom")
+
+		out := &bytes.Buffer{}
+		if err := writeMetrics(registry, output, out); err != nil {
+			t.Fatal(err)
+		}
+
+		if out.Len() > 0 {
+			t.Errorf("Nothing should be written to stdout when there is an output file, got %q", out.String())
+		}
+
+		content, err := ioutil.ReadFile(output)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(content) != want {
+			t.Errorf("writeMetrics() wrote %q, want %q", string(content), want)
+		}
+
+		//The temporary file is renamed into place, so only the output should be left behind
+		files, _ := ioutil.ReadDir(dir)
+		if len(files) != 1 {
+			t.Errorf("Expected only the output file to be left behind, found %d files", len(files))
+		}
+	})
+
+	t.Run("Missing directory", func(t *testing.T) {
+		if err := writeMetrics(registry, "/foo/bar/baz/sge.prom", &bytes.Buffer{}); err == nil {
+			t.Error("Expected an error writing to a directory that doesn't exist")
+		}
+	})
+}
@@ -0,0 +1,122 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	log "github.com/sirupsen/logrus"
+)
+
+//errUnknownTarget is returned when a probe names a cluster that isn't in the configuration
+var errUnknownTarget = errors.New("unknown target")
+
+//probeHandler runs the collectors against the cluster named by the target parameter on every request, in the style of
+//the blackbox exporter, so Prometheus service discovery can decide which cells are scraped and how often. Clusters come
+//from whichever configuration was set most recently, so a cell added to the config file can be probed after a reload
+type probeHandler struct {
+	mutex  sync.Mutex
+	config Config
+	//registries are the collectors of each cluster probed so far. They are kept rather than set up for every probe so
+	//that probes of a cluster that overlap share a single run of qstat
+	registries map[string]*prometheus.Registry
+	//ctx is the context the background work of the collectors runs in, until the configuration is next set
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+//Set starts probing the clusters of the configuration
+func (h *probeHandler) Set(config Config) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if h.cancel != nil {
+		h.cancel()
+	}
+
+	h.config = config
+	h.registries = make(map[string]*prometheus.Registry)
+	h.ctx, h.cancel = context.WithCancel(context.Background())
+}
+
+func (h *probeHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	target := r.URL.Query().Get("target")
+	if len(target) == 0 {
+		http.Error(w, "target parameter is missing", http.StatusBadRequest)
+		return
+	}
+
+	registry, err := h.registry(target)
+	if errors.Is(err, errUnknownTarget) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.WithError(err).Errorf("Unable to probe cluster %s", target)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	promhttp.HandlerFor(registry, promhttp.HandlerOpts{}).ServeHTTP(w, r)
+}
+
+//registry returns the collectors of the target, setting them up the first time it is probed
+func (h *probeHandler) registry(target string) (*prometheus.Registry, error) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if registry, ok := h.registries[target]; ok {
+		return registry, nil
+	}
+
+	if h.registries == nil {
+		h.registries = make(map[string]*prometheus.Registry)
+		h.ctx, h.cancel = context.WithCancel(context.Background())
+	}
+
+	registry, err := newProbeRegistry(h.ctx, h.config, target)
+	if err != nil {
+		return nil, err
+	}
+
+	h.registries[target] = registry
+
+	return registry, nil
+}
+
+//newProbeRegistry registers the collectors the configuration enables for just the named cluster. Everything is run as
+//the metrics are gathered, and accounting and job events are left out as their counters would start from zero on
+//every probe
+func newProbeRegistry(ctx context.Context, config Config, target string) (*prometheus.Registry, error) {
+	config.PollInterval = 0
+	config.Accounting = false
+	config.JobEvents = false
+
+	clusters := config.clusters()
+
+	for i, cluster := range clusters {
+		if cluster.Name != target {
+			continue
+		}
+
+		registry := prometheus.NewRegistry()
+		registerer := prometheus.WrapRegistererWith(prometheus.Labels{"cluster": cluster.Name}, registry)
+
+		starts, err := registerCluster(registerer, config, cluster, i, len(clusters) > 1)
+		if err != nil {
+			return nil, fmt.Errorf("unable to set up cluster %s: %w", cluster.Name, err)
+		}
+
+		for _, start := range starts {
+			start(ctx)
+		}
+
+		return registry, nil
+	}
+
+	return nil, fmt.Errorf("%w %s. Targets are the names of the configured clusters", errUnknownTarget, target)
+}
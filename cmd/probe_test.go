@@ -0,0 +1,109 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestProbeHandler(t *testing.T) {
+	config := Config{
+		Test:                 true,
+		SimulatorSeed:        1,
+		SimulatorHosts:       2,
+		SimulatorQueues:      []string{"all.q"},
+		SimulatorSlots:       4,
+		SimulatorArrivalRate: 2,
+		Namespace:            "sge",
+		Clusters: []ClusterConfig{
+			{Name: "east"},
+			{Name: "west"},
+		},
+	}
+
+	handler := &probeHandler{}
+	handler.Set(config)
+
+	probe := func(url string) (int, string) {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest("GET", url, nil))
+		body, _ := ioutil.ReadAll(recorder.Body)
+		return recorder.Code, string(body)
+	}
+
+	tests := []struct {
+		name       string
+		url        string
+		wantStatus int
+		want       string
+		dontWant   string
+	}{
+		{
+			name:       "Missing target",
+			url:        "/probe",
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "Unknown target",
+			url:        "/probe?target=north",
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name:       "Known target",
+			url:        "/probe?target=west",
+			wantStatus: http.StatusOK,
+			want:       `sge_up{cluster="west"} 1`,
+			dontWant:   `cluster="east"`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, body := probe(tt.url)
+
+			if status != tt.wantStatus {
+				t.Fatalf("Probe status = %d, want %d: %s", status, tt.wantStatus, body)
+			}
+
+			if !strings.Contains(body, tt.want) {
+				t.Errorf("Expected %s in the probe, got %s", tt.want, body)
+			}
+
+			if len(tt.dontWant) > 0 && strings.Contains(body, tt.dontWant) {
+				t.Errorf("Expected only the target cluster in the probe, got %s", body)
+			}
+		})
+	}
+
+	t.Run("Probes of a cluster share its collectors", func(t *testing.T) {
+		first, err := handler.registry("west")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if again, _ := handler.registry("west"); again != first {
+			t.Error("Expected every probe of a cluster to gather from the same collectors")
+		}
+
+		if east, _ := handler.registry("east"); east == first {
+			t.Error("Expected each cluster to have collectors of its own")
+		}
+
+		handler.Set(config)
+
+		if reloaded, _ := handler.registry("west"); reloaded == first {
+			t.Error("Expected a reload to set the collectors up again")
+		}
+	})
+
+	t.Run("Cluster added by a reload", func(t *testing.T) {
+		reloaded := config
+		reloaded.Clusters = append([]ClusterConfig{{Name: "north"}}, config.Clusters...)
+		handler.Set(reloaded)
+
+		if status, body := probe("/probe?target=north"); status != http.StatusOK {
+			t.Errorf("Probe status = %d, want %d: %s", status, http.StatusOK, body)
+		}
+	})
+}
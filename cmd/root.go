@@ -1,20 +1,21 @@
 package cmd
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"github.com/metrumresearchgroup/gridengine_prometheus"
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/client_golang/prometheus/collectors"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 	"io/ioutil"
 	"math/rand"
-	"net/http"
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
 	"syscall"
 	"time"
 )
@@ -40,70 +41,298 @@ func Start(cmd *cobra.Command, args []string) error {
 	entropy = rand.NewSource(time.Now().UnixNano())
 	random = rand.New(entropy)
 
-	if viper.GetBool("test") {
-		//set the underlying gogridengine variable
-		err := os.Setenv("GOGRIDENGINE_TEST", "true")
+	config, err := loadConfig()
+	if err != nil {
+		return err
+	}
 
+	if config.Debug {
+		viper.Debug()
+	}
+
+	if len(config.Pidfile) > 0 {
+		err = writePidFile(viper.GetString("pidfile"))
 		if err != nil {
-			log.Fatalf("Attempting to set Gogridengine test variables failed: %s", err)
+			log.Error("Unable to setup PID. Continuing without a PID File. Failure caused by: %w", err.Error())
+		} else {
+			defer removePidFile(viper.GetString("pidfile"))
 		}
 	}
 
+	return serve(config)
+}
+
+//loadConfig reads the configuration from flags and the config file and validates it. Used both at startup and when
+//reloading
+func loadConfig() (Config, error) {
+	var config Config
+
 	if len(viper.GetString("config")) > 0 {
 		err := readProvidedConfig(viper.GetString("config"))
 		if err != nil {
-			log.Fatalf("Attempting to open config file %s failed with error %s", viper.GetString("config"), err)
+			return config, fmt.Errorf("attempting to open config file %s failed: %w", viper.GetString("config"), err)
 		}
 	}
 
-	var config Config
-
 	if err := viper.Unmarshal(&config); err != nil {
-		return fmt.Errorf("failed to retrieve viper details: %w", err)
-	}
-
-	if config.Debug {
-		viper.Debug()
+		return config, fmt.Errorf("failed to retrieve viper details: %w", err)
 	}
 
 	//Die if we don't have all the SGE configurations required.
-	err := validateSGE(config)
+	err := validateClusters(config.clusters())
 	if err != nil {
-		return fmt.Errorf("failed to validate SGE configuration: %w", err)
+		return config, fmt.Errorf("failed to validate SGE configuration: %w", err)
 	}
 
-	//Set the SGE Envs for the application
-	err = setSGEEnvironmentVariables(config)
+	if config.Accounting && config.AccountingInterval <= 0 {
+		return config, fmt.Errorf("accounting_interval must be greater than 0, not %s", config.AccountingInterval)
+	}
+
+	return config, nil
+}
+
+//newRegistry registers every collector the configuration enables along with the Go and process details of the
+//exporter itself. Background work for the collectors, such as polling qstat, carries on until the context is done
+func newRegistry(ctx context.Context, config Config) (*prometheus.Registry, error) {
+	registry, err := newGridEngineRegistry(ctx, config)
 	if err != nil {
-		log.Fatalf("Unable to set SGE environment variables. Details: %s", err)
+		return nil, err
 	}
 
-	if len(config.Pidfile) > 0 {
-		err = writePidFile(viper.GetString("pidfile"))
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+
+	return registry, nil
+}
+
+//newGridEngineRegistry registers only the grid engine collectors the configuration enables, for every cluster. Each
+//metric is labelled with the name of the cluster it came from
+func newGridEngineRegistry(ctx context.Context, config Config) (*prometheus.Registry, error) {
+	registry := prometheus.NewRegistry()
+
+	clusters := config.clusters()
+
+	//Nothing is started until every cluster has been set up, so a bad cluster doesn't leave the others running
+	starts := make([]func(context.Context), 0)
+
+	for i, cluster := range clusters {
+		registerer := prometheus.WrapRegistererWith(prometheus.Labels{"cluster": cluster.Name}, registry)
+
+		clusterStarts, err := registerCluster(registerer, config, cluster, i, len(clusters) > 1)
 		if err != nil {
-			log.Error("Unable to setup PID. Continuing without a PID File. Failure caused by: %w", err.Error())
+			return nil, fmt.Errorf("unable to set up cluster %s: %w", cluster.Name, err)
+		}
+
+		starts = append(starts, clusterStarts...)
+	}
+
+	for _, start := range starts {
+		start(ctx)
+	}
+
+	return registry, nil
+}
+
+//registerCluster registers the collectors the configuration enables for a single cluster, the index-th of those
+//configured. When there are several clusters, anything written to disk is kept apart by the name of the cluster. The
+//background work of the collectors is returned to be started once every cluster is ready
+func registerCluster(registerer prometheus.Registerer, config Config, cluster ClusterConfig, index int, several bool) ([]func(context.Context), error) {
+	starts := make([]func(context.Context), 0)
+
+	source, err := newSource(config, cluster, index, several)
+	if err != nil {
+		return nil, err
+	}
+
+	//A zero interval leaves the poller on demand, running qstat on every scrape
+	sge := gridengine_prometheus.NewGridEngine(
+		gridengine_prometheus.WithNamespace(config.Namespace),
+		gridengine_prometheus.WithLegacyNames(config.LegacyMetricNames),
+		gridengine_prometheus.WithSource(source),
+		gridengine_prometheus.WithPollInterval(config.PollInterval),
+		gridengine_prometheus.WithRetries(config.ExecRetries, config.ExecRetryBackoff),
+	)
+	sge.ShortHostnames = config.ShortHostnames
+	sge.DisableJobSeries = config.DisableJobSeries
+	sge.MaxJobSeries = config.MaxJobSeries
+	sge.Resources = gridengine_prometheus.ResourceFilter{
+		Allow: config.ResourceAllowlist,
+		Deny:  config.ResourceDenylist,
+	}
+
+	if len(config.RecordDir) > 0 {
+		dir := config.RecordDir
+		if several {
+			dir = filepath.Join(dir, cluster.Name)
+		}
+
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, fmt.Errorf("unable to create the directory to record qstat output into: %w", err)
 		}
+
+		sge.Poller.Recorder = gridengine_prometheus.NewRecorder(dir, config.RecordMaxFiles, config.RecordMaxAge)
+		log.Infof("Recording qstat output for cluster %s into %s", cluster.Name, dir)
+	}
+
+	if config.JobEvents {
+		events := gridengine_prometheus.NewJobEventCollector(config.Namespace, clusterStatePath(config.JobEventsStateFile, cluster, several))
+		sge.Poller.Events = events
+		registerer.MustRegister(events)
 	}
 
-	sge := gridengine_prometheus.NewGridEngine()
-	prometheus.MustRegister(sge)
+	starts = append(starts, sge.Poller.Start)
 
-	http.Handle("/metrics", promhttp.Handler())
+	registerer.MustRegister(sge)
 
-	log.Infof("Getting ready to start exporter on port %d", viper.GetInt("port"))
+	//Test and replay modes only fake qstat, so there is nothing else to report on
+	live := !config.Test && len(config.ReplayDir) == 0
 
-	log.Fatal(http.ListenAndServe(fmt.Sprintf(":%d", viper.GetInt("port")), nil))
+	//The other commands are run on every scrape, so share what is in flight and retry them just as qstat is
+	runner := gridengine_prometheus.NewCommandRunner(cluster.cluster(config.ExecTimeout), config.ExecRetries, config.ExecRetryBackoff)
 
-	return nil
+	if config.Qhost && live {
+		hosts := gridengine_prometheus.NewHostCollector(config.Namespace)
+		hosts.ShortHostnames = config.ShortHostnames
+		hosts.Runner = runner
+		registerer.MustRegister(hosts)
+	}
+
+	if config.Qquota && live {
+		quotas := gridengine_prometheus.NewQuotaCollector(config.Namespace)
+		quotas.Runner = runner
+		registerer.MustRegister(quotas)
+	}
+
+	if config.ShareTree && live {
+		path := cluster.ShareMon
+		if len(path) == 0 {
+			path = config.ShareMon
+		}
+		if len(path) == 0 {
+			path = gridengine_prometheus.ShareMonPath(cluster.Root, cluster.Arch)
+		}
+
+		shares := gridengine_prometheus.NewShareTreeCollector(config.Namespace, path)
+		shares.Runner = runner
+		registerer.MustRegister(shares)
+	}
+
+	if config.PendingReasons && live {
+		reasons := gridengine_prometheus.NewPendingReasonCollector(config.Namespace)
+		reasons.Runner = runner
+		registerer.MustRegister(reasons)
+	}
+
+	if config.Accounting {
+		path := cluster.AccountingFile
+		if len(path) == 0 {
+			path = config.AccountingFile
+		}
+		if len(path) == 0 {
+			path = gridengine_prometheus.AccountingPath(cluster.Root, cluster.Cell)
+		}
+
+		statePath := clusterStatePath(config.AccountingStateFile, cluster, several)
+		accounting := gridengine_prometheus.NewAccountingCollector(config.Namespace, path, statePath, config.AccountingInterval)
+		starts = append(starts, accounting.Start)
+		registerer.MustRegister(accounting)
+		log.Infof("Tailing accounting file %s for cluster %s", path, cluster.Name)
+	}
+
+	return starts, nil
+}
+
+//clusterStatePath keeps the state files of several clusters apart by adding the name of the cluster to the file name
+func clusterStatePath(path string, cluster ClusterConfig, several bool) string {
+	if !several || len(path) == 0 {
+		return path
+	}
+
+	extension := filepath.Ext(path)
+	return strings.TrimSuffix(path, extension) + "-" + cluster.Name + extension
+}
+
+//newSource picks where the qstat XML for the index-th cluster comes from: a simulated cluster in test mode, saved
+//recordings when replaying, or qstat itself. Each simulated cluster is seeded differently so they don't all look the
+//same, and with several clusters the recordings of each are replayed from a directory named after it
+func newSource(config Config, cluster ClusterConfig, index int, several bool) (gridengine_prometheus.Source, error) {
+	if config.Test {
+		simulation := gridengine_prometheus.SimulatorConfig{
+			Seed:        config.SimulatorSeed + int64(index),
+			Hosts:       config.SimulatorHosts,
+			Queues:      config.SimulatorQueues,
+			Slots:       config.SimulatorSlots,
+			ArrivalRate: config.SimulatorArrivalRate,
+			FailureRate: config.SimulatorFailureRate,
+			Start:       time.Now(),
+			Step:        config.PollInterval,
+		}
+
+		log.Infof("Running cluster %s in test mode against a simulated cluster", cluster.Name)
+		return gridengine_prometheus.NewSimulator(simulation), nil
+	}
+
+	if len(config.ReplayDir) > 0 {
+		dir := config.ReplayDir
+		if several {
+			dir = filepath.Join(dir, cluster.Name)
+		}
+
+		replay, err := gridengine_prometheus.NewReplay(dir)
+		if err != nil {
+			return nil, err
+		}
+
+		log.Infof("Replaying %d qstat recordings for cluster %s from %s", len(replay.Files), cluster.Name, dir)
+		return replay, nil
+	}
+
+	return gridengine_prometheus.QstatSource{Cluster: cluster.cluster(config.ExecTimeout)}, nil
 }
 
 func init() {
 	pidFileIdentifier := "pidfile"
 	RootCmd.PersistentFlags().String(pidFileIdentifier, "/var/run/"+ServiceName, "Location in which to store a pidfile. Most useful for SystemV daemons")
 	RootCmd.PersistentFlags().Int("port", 9081, "The port on which the collector should listen")
-	RootCmd.PersistentFlags().Bool("test", false, "Indicates whether the underlying gogridengine should be run in test mode")
+	RootCmd.PersistentFlags().Bool("test", false, "Serve metrics from a simulated cluster rather than running qstat")
+	RootCmd.PersistentFlags().Int64("simulator_seed", 1, "Seed for the simulated cluster in test mode. The same seed always produces the same cluster activity")
+	RootCmd.PersistentFlags().Int("simulator_hosts", 4, "Number of hosts in the simulated cluster")
+	RootCmd.PersistentFlags().StringSlice("simulator_queues", []string{"all.q"}, "Queues of the simulated cluster, each of which has an instance on every host")
+	RootCmd.PersistentFlags().Int("simulator_slots", 8, "Slots of each queue instance in the simulated cluster")
+	RootCmd.PersistentFlags().Float64("simulator_arrival_rate", 2, "Average number of jobs submitted to the simulated cluster on each refresh")
+	RootCmd.PersistentFlags().Float64("simulator_failure_rate", 0.02, "Probability of a simulated job failing into an error state, and of a simulated host being unreachable on each refresh")
 	RootCmd.PersistentFlags().String("config", "", "Specifies a viper config to load. Should be in yaml format")
 	RootCmd.PersistentFlags().Bool("debug", false, "Whether or not debug is on")
+	RootCmd.PersistentFlags().String("web.config.file", "", "Path to a web config file, in the Prometheus exporter-toolkit format, enabling TLS and basic auth on the metrics endpoint")
+	RootCmd.PersistentFlags().Duration("shutdown_timeout", 30*time.Second, "How long to wait for in-flight scrapes to finish when asked to stop")
+	RootCmd.PersistentFlags().Bool("qhost", true, "Whether to report host details from qhost alongside the queue instance metrics")
+	RootCmd.PersistentFlags().Bool("qquota", true, "Whether to report the limit and usage of resource quota rules from qquota")
+	RootCmd.PersistentFlags().Bool("share_tree", false, "Whether to report share tree shares and usage from sge_share_mon")
+	RootCmd.PersistentFlags().String("share_mon", "", "Location of sge_share_mon. Defaults to $SGE_ROOT/utilbin/$SGE_ARCH/sge_share_mon")
+	RootCmd.PersistentFlags().Bool("pending_reasons", false, "Whether to report why pending jobs aren't running from the scheduler messages in qstat -j. Requires schedd_job_info")
+	RootCmd.PersistentFlags().Bool("accounting", false, "Whether to tail the SGE accounting file and report on finished jobs")
+	RootCmd.PersistentFlags().String("accounting_file", "", "Location of the accounting file. Defaults to $SGE_ROOT/$SGE_CELL/common/accounting")
+	RootCmd.PersistentFlags().String("accounting_state_file", "/var/lib/"+ServiceName+"/accounting.json", "Where to persist the position in the accounting file between restarts. Empty disables persistence")
+	RootCmd.PersistentFlags().Duration("accounting_interval", gridengine_prometheus.DefaultAccountingInterval, "How often to check the accounting file for newly finished jobs")
+	RootCmd.PersistentFlags().Bool("job_events", false, "Whether to count jobs starting, finishing and entering an error state by comparing consecutive qstat snapshots")
+	RootCmd.PersistentFlags().String("job_events_state_file", "/var/lib/"+ServiceName+"/job_events.json", "Where to persist the last snapshot's jobs and the job event counts between restarts. Empty disables persistence")
+	RootCmd.PersistentFlags().Bool("short_hostnames", false, "Strip the domain from hostnames so they are reported as short names rather than FQDNs")
+	RootCmd.PersistentFlags().Bool("disable_job_series", false, "Only report jobs aggregated by owner, queue and state rather than a series per job")
+	RootCmd.PersistentFlags().Int("max_job_series", 0, "Only report per job series for this many of the highest priority jobs. 0 is unlimited")
+	RootCmd.PersistentFlags().String("namespace", gridengine_prometheus.DefaultNamespace, "Prefix applied to the name of every metric")
+	RootCmd.PersistentFlags().Bool("legacy_metric_names", true, "Also report metrics under the names used before they were namespaced. Will be removed in a future release")
+	RootCmd.PersistentFlags().StringSlice("resource_allowlist", nil, "Names or glob patterns of the queue resources to report. Empty reports every numeric resource")
+	RootCmd.PersistentFlags().StringSlice("resource_denylist", nil, "Names or glob patterns of queue resources never to report. Takes precedence over the allowlist")
+	RootCmd.PersistentFlags().Duration("poll_interval", 30*time.Second, "How often to refresh qstat in the background. 0 runs qstat on every scrape instead")
+	RootCmd.PersistentFlags().Duration("exec_timeout", 30*time.Second, "How long qstat and the other grid engine commands may run before they are killed along with anything they started. 0 waits forever")
+	RootCmd.PersistentFlags().Int("exec_retries", 2, "How many more times to run a grid engine command when it fails to run")
+	RootCmd.PersistentFlags().Duration("exec_retry_backoff", time.Second, "How long to wait before the first retry of a grid engine command. Doubles for each retry after")
+	RootCmd.PersistentFlags().String("record_dir", "", "Directory to save every raw qstat payload into, for replaying or attaching to bug reports. Empty disables recording")
+	RootCmd.PersistentFlags().Int("record_max_files", 1000, "How many qstat recordings to keep. 0 is unlimited")
+	RootCmd.PersistentFlags().Duration("record_max_age", 24*time.Hour, "How long to keep qstat recordings. 0 keeps them forever")
+	RootCmd.PersistentFlags().String("replay_dir", "", "Serve metrics from the qstat recordings in this directory, one per refresh in order, rather than running qstat")
 
 	//SGE Configurations
 	RootCmd.PersistentFlags().String("sge_arch", "lx-amd64", "Identifies the architecture of the Sun Grid Engine")
@@ -114,6 +343,11 @@ func init() {
 	RootCmd.PersistentFlags().String("sge_cluster_name", "p6444", "Name of the SGE Cluster to bind to")
 
 	_ = viper.BindPFlags(RootCmd.PersistentFlags())
+
+	//The SGE flags fill in the sge block of the config file
+	for _, key := range []string{"arch", "cell", "execd_port", "qmaster_port", "root", "cluster_name"} {
+		_ = viper.BindPFlag(viperSGEKey+key, RootCmd.PersistentFlags().Lookup("sge_"+key))
+	}
 }
 
 func writePidFile(pidFile string) error {
@@ -137,6 +371,22 @@ func writePidFile(pidFile string) error {
 	return ioutil.WriteFile(location, []byte(fmt.Sprintf("%d", os.Getpid())), 0664)
 }
 
+//removePidFile cleans up the pidfile on exit, as long as it is still ours
+func removePidFile(pidFile string) {
+	piddata, err := ioutil.ReadFile(pidFile)
+	if err != nil {
+		return
+	}
+
+	if pid, err := strconv.Atoi(string(piddata)); err != nil || pid != os.Getpid() {
+		return
+	}
+
+	if err := os.Remove(pidFile); err != nil {
+		log.WithError(err).Errorf("Unable to remove pidfile %s", pidFile)
+	}
+}
+
 func readProvidedConfig(path string) error {
 	viper.SetConfigType("yaml")
 
@@ -150,86 +400,150 @@ func readProvidedConfig(path string) error {
 	return viper.ReadConfig(file)
 }
 
-func validateSGE(config Config) error {
-
-	if len(config.SGE.Arch) == 0 {
-		return errors.New("the SGE architecture has not been provided")
-	}
+//validateClusters checks every cluster has all the SGE configuration required, and that no two share a name
+func validateClusters(clusters []ClusterConfig) error {
+	names := make(map[string]bool)
 
-	if len(config.SGE.Cell) == 0 {
-		return errors.New("no valid SGE cell has been configured")
-	}
+	for _, cluster := range clusters {
+		if len(cluster.Name) == 0 {
+			return errors.New("every cluster needs a name")
+		}
 
-	if config.SGE.ExecdPort == 0 {
-		return errors.New("no ExecD port has been specified for SGE binding")
-	}
+		if names[cluster.Name] {
+			return fmt.Errorf("there is more than one cluster named %s", cluster.Name)
+		}
 
-	if config.SGE.QmasterPort == 0 {
-		return errors.New("no Qmaster port has been specified for SGE Binding")
-	}
+		names[cluster.Name] = true
 
-	if len(config.SGE.ClusterName) == 0 {
-		return errors.New("no SGE cluster name has been provided")
+		if err := validateSGE(cluster.SGE); err != nil {
+			return fmt.Errorf("cluster %s: %w", cluster.Name, err)
+		}
 	}
 
 	return nil
 }
 
-func setSGEEnvironmentVariables(config Config) error {
-	err := os.Setenv("SGE_ARCH", config.SGE.Arch)
-	if err != nil {
-		return err
-	}
+func validateSGE(sge SGE) error {
 
-	err = os.Setenv("SGE_CELL", config.SGE.Cell)
-
-	if err != nil {
-		return err
+	if len(sge.Arch) == 0 {
+		return errors.New("the SGE architecture has not been provided")
 	}
 
-	err = os.Setenv("SGE_EXECD_PORT", strconv.Itoa(config.SGE.ExecdPort))
-
-	if err != nil {
-		return err
+	if len(sge.Cell) == 0 {
+		return errors.New("no valid SGE cell has been configured")
 	}
 
-	err = os.Setenv("SGE_QMASTER_PORT", strconv.Itoa(config.SGE.QmasterPort))
-
-	if err != nil {
-		return err
+	if sge.ExecdPort == 0 {
+		return errors.New("no ExecD port has been specified for SGE binding")
 	}
 
-	err = os.Setenv("SGE_ROOT", config.SGE.Root)
+	if sge.QmasterPort == 0 {
+		return errors.New("no Qmaster port has been specified for SGE Binding")
+	}
 
-	if err != nil {
-		return err
+	if len(sge.ClusterName) == 0 {
+		return errors.New("no SGE cluster name has been provided")
 	}
 
-	//Update Path to include SGE_ROOT BIN and any dirs matching  arch path
-	path := os.Getenv("PATH")
-	binPath := filepath.Join(config.SGE.Root, "bin")
-	archPath := filepath.Join(binPath, config.SGE.Arch)
-	err = os.Setenv("PATH", path+":"+binPath+":"+archPath)
+	return nil
+}
 
-	if err != nil {
-		return err
-	}
+type Config struct {
+	Test bool `yaml:"test" json:"test"`
+	//Simulator settings shape the cluster simulated in test mode
+	SimulatorSeed        int64    `mapstructure:"simulator_seed" yaml:"simulator_seed" json:"simulator_seed"`
+	SimulatorHosts       int      `mapstructure:"simulator_hosts" yaml:"simulator_hosts" json:"simulator_hosts"`
+	SimulatorQueues      []string `mapstructure:"simulator_queues" yaml:"simulator_queues" json:"simulator_queues"`
+	SimulatorSlots       int      `mapstructure:"simulator_slots" yaml:"simulator_slots" json:"simulator_slots"`
+	SimulatorArrivalRate float64  `mapstructure:"simulator_arrival_rate" yaml:"simulator_arrival_rate" json:"simulator_arrival_rate"`
+	SimulatorFailureRate float64  `mapstructure:"simulator_failure_rate" yaml:"simulator_failure_rate" json:"simulator_failure_rate"`
+	Port                 int      `yaml:"port" josn:"port"`
+	Pidfile              string   `yaml:"pidfile" json:"pidfile"`
+	SGE                  SGE      `mapstructure:"sge"`
+	//Clusters are the grid engine cells to report on. When none are listed the cell in SGE is reported on
+	Clusters []ClusterConfig `mapstructure:"clusters" yaml:"clusters" json:"clusters"`
+	Debug    bool            `mapstructure:"debug" yaml:"debug"`
+	//ShutdownTimeout is how long in-flight scrapes are given to finish on SIGTERM or SIGINT
+	ShutdownTimeout time.Duration `mapstructure:"shutdown_timeout" yaml:"shutdown_timeout" json:"shutdown_timeout"`
+	//Namespace prefixes every metric name. LegacyMetricNames also reports metrics under their original names
+	Namespace         string `mapstructure:"namespace" yaml:"namespace" json:"namespace"`
+	LegacyMetricNames bool   `mapstructure:"legacy_metric_names" yaml:"legacy_metric_names" json:"legacy_metric_names"`
+	//PollInterval is how often qstat is refreshed in the background
+	PollInterval time.Duration `mapstructure:"poll_interval" yaml:"poll_interval" json:"poll_interval"`
+	//ExecTimeout kills grid engine commands that hang. Failed qstat runs are retried ExecRetries times, waiting
+	//ExecRetryBackoff before the first
+	ExecTimeout      time.Duration `mapstructure:"exec_timeout" yaml:"exec_timeout" json:"exec_timeout"`
+	ExecRetries      int           `mapstructure:"exec_retries" yaml:"exec_retries" json:"exec_retries"`
+	ExecRetryBackoff time.Duration `mapstructure:"exec_retry_backoff" yaml:"exec_retry_backoff" json:"exec_retry_backoff"`
+	//RecordDir saves every qstat payload, keeping at most RecordMaxFiles no older than RecordMaxAge
+	RecordDir      string        `mapstructure:"record_dir" yaml:"record_dir" json:"record_dir"`
+	RecordMaxFiles int           `mapstructure:"record_max_files" yaml:"record_max_files" json:"record_max_files"`
+	RecordMaxAge   time.Duration `mapstructure:"record_max_age" yaml:"record_max_age" json:"record_max_age"`
+	//ReplayDir serves recorded qstat payloads instead of running qstat
+	ReplayDir string `mapstructure:"replay_dir" yaml:"replay_dir" json:"replay_dir"`
+	//Qhost enables the qhost based host collector
+	Qhost bool `mapstructure:"qhost" yaml:"qhost" json:"qhost"`
+	//ShortHostnames strips domains from reported hostnames
+	ShortHostnames bool `mapstructure:"short_hostnames" yaml:"short_hostnames" json:"short_hostnames"`
+	//DisableJobSeries and MaxJobSeries bound the cardinality of the per job metrics
+	DisableJobSeries bool `mapstructure:"disable_job_series" yaml:"disable_job_series" json:"disable_job_series"`
+	MaxJobSeries     int  `mapstructure:"max_job_series" yaml:"max_job_series" json:"max_job_series"`
+	//ResourceAllowlist and ResourceDenylist select which queue resources are reported
+	ResourceAllowlist []string `mapstructure:"resource_allowlist" yaml:"resource_allowlist" json:"resource_allowlist"`
+	ResourceDenylist  []string `mapstructure:"resource_denylist" yaml:"resource_denylist" json:"resource_denylist"`
+	//Qquota enables the qquota based resource quota collector
+	Qquota bool `mapstructure:"qquota" yaml:"qquota" json:"qquota"`
+	//ShareTree enables the sge_share_mon based share tree collector
+	ShareTree bool   `mapstructure:"share_tree" yaml:"share_tree" json:"share_tree"`
+	ShareMon  string `mapstructure:"share_mon" yaml:"share_mon" json:"share_mon"`
+	//PendingReasons enables the qstat -j based pending reason collector
+	PendingReasons bool `mapstructure:"pending_reasons" yaml:"pending_reasons" json:"pending_reasons"`
+	//Accounting enables tailing of the accounting file
+	Accounting          bool          `mapstructure:"accounting" yaml:"accounting" json:"accounting"`
+	AccountingFile      string        `mapstructure:"accounting_file" yaml:"accounting_file" json:"accounting_file"`
+	AccountingStateFile string        `mapstructure:"accounting_state_file" yaml:"accounting_state_file" json:"accounting_state_file"`
+	AccountingInterval  time.Duration `mapstructure:"accounting_interval" yaml:"accounting_interval" json:"accounting_interval"`
+	//JobEvents enables counting job lifecycle events between snapshots
+	JobEvents          bool   `mapstructure:"job_events" yaml:"job_events" json:"job_events"`
+	JobEventsStateFile string `mapstructure:"job_events_state_file" yaml:"job_events_state_file" json:"job_events_state_file"`
+}
 
-	err = os.Setenv("SGE_CLUSTER_NAME", config.SGE.ClusterName)
+//clusters are the grid engine cells the configuration reports on. The sge block is used when no clusters are listed,
+//named after its SGE cluster name
+func (config Config) clusters() []ClusterConfig {
+	if len(config.Clusters) > 0 {
+		return config.Clusters
+	}
 
-	if err != nil {
-		return err
+	return []ClusterConfig{
+		{
+			Name: config.SGE.ClusterName,
+			SGE:  config.SGE,
+		},
 	}
+}
 
-	return nil
+//ClusterConfig is a single grid engine cell to report on. ShareMon and AccountingFile override share_mon and
+//accounting_file for just this cell
+type ClusterConfig struct {
+	Name           string `mapstructure:"name" yaml:"name" json:"name"`
+	SGE            `mapstructure:",squash" yaml:",inline"`
+	ShareMon       string `mapstructure:"share_mon" yaml:"share_mon" json:"share_mon"`
+	AccountingFile string `mapstructure:"accounting_file" yaml:"accounting_file" json:"accounting_file"`
 }
 
-type Config struct {
-	Test    bool   `yaml:"test" json:"test"`
-	Port    int    `yaml:"port" josn:"port"`
-	Pidfile string `yaml:"pidfile" json:"pidfile"`
-	SGE     SGE    `mapstructure:"sge"`
-	Debug   bool   `mapstructure:"debug" yaml:"debug"`
+//cluster is where the commands of the cell are run, each being killed once it has run for timeout
+func (c ClusterConfig) cluster(timeout time.Duration) gridengine_prometheus.Cluster {
+	return gridengine_prometheus.Cluster{
+		Name:        c.Name,
+		Arch:        c.Arch,
+		Cell:        c.Cell,
+		Root:        c.Root,
+		ExecdPort:   c.ExecdPort,
+		QmasterPort: c.QmasterPort,
+		ClusterName: c.ClusterName,
+		Timeout:     timeout,
+	}
 }
 
 type SGE struct {
@@ -1,6 +1,11 @@
 package cmd
 
 import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
 )
 
@@ -37,4 +42,174 @@ func TestWritePidFile(t *testing.T) {
 			}
 		})
 	}
-}
\ No newline at end of file
+}
+
+func TestRemovePidFile(t *testing.T) {
+	dir := t.TempDir()
+
+	ours := filepath.Join(dir, "ours.pid")
+	if err := writePidFile(ours); err != nil {
+		t.Fatal(err)
+	}
+
+	theirs := filepath.Join(dir, "theirs.pid")
+	if err := ioutil.WriteFile(theirs, []byte(fmt.Sprintf("%d", os.Getpid()+1)), 0664); err != nil {
+		t.Fatal(err)
+	}
+
+	removePidFile(ours)
+	removePidFile(theirs)
+	removePidFile(filepath.Join(dir, "missing.pid"))
+
+	if _, err := os.Stat(ours); !os.IsNotExist(err) {
+		t.Errorf("Expected our pidfile to be removed, got %v", err)
+	}
+
+	if _, err := os.Stat(theirs); err != nil {
+		t.Errorf("Expected another process's pidfile to be left alone, got %v", err)
+	}
+}
+
+func TestValidateClusters(t *testing.T) {
+	sge := SGE{
+		Arch:        "lx-amd64",
+		Cell:        "default",
+		ExecdPort:   6445,
+		QmasterPort: 6444,
+		Root:        "/opt/sge",
+		ClusterName: "p6444",
+	}
+
+	incomplete := sge
+	incomplete.Cell = ""
+
+	tests := []struct {
+		name     string
+		config   Config
+		wantErr  bool
+		wantName []string
+	}{
+		{
+			name:     "Single cluster from the sge block",
+			config:   Config{SGE: sge},
+			wantName: []string{"p6444"},
+		},
+		{
+			name: "Several clusters",
+			config: Config{
+				SGE: sge,
+				Clusters: []ClusterConfig{
+					{Name: "east", SGE: sge},
+					{Name: "west", SGE: sge},
+				},
+			},
+			wantName: []string{"east", "west"},
+		},
+		{
+			name: "Duplicate names",
+			config: Config{
+				Clusters: []ClusterConfig{
+					{Name: "east", SGE: sge},
+					{Name: "east", SGE: sge},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "Missing name",
+			config: Config{
+				Clusters: []ClusterConfig{
+					{SGE: sge},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "Incomplete cluster",
+			config: Config{
+				Clusters: []ClusterConfig{
+					{Name: "east", SGE: sge},
+					{Name: "west", SGE: incomplete},
+				},
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clusters := tt.config.clusters()
+
+			if err := validateClusters(clusters); (err != nil) != tt.wantErr {
+				t.Fatalf("validateClusters() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if tt.wantErr {
+				return
+			}
+
+			if len(clusters) != len(tt.wantName) {
+				t.Fatalf("clusters() = %v, want %v", clusters, tt.wantName)
+			}
+
+			for i, cluster := range clusters {
+				if cluster.Name != tt.wantName[i] {
+					t.Errorf("clusters()[%d] = %s, want %s", i, cluster.Name, tt.wantName[i])
+				}
+			}
+		})
+	}
+}
+
+func TestNewGridEngineRegistry_Clusters(t *testing.T) {
+	config := Config{
+		Test:                 true,
+		SimulatorSeed:        1,
+		SimulatorHosts:       2,
+		SimulatorQueues:      []string{"all.q"},
+		SimulatorSlots:       4,
+		SimulatorArrivalRate: 2,
+		Namespace:            "sge",
+		Clusters: []ClusterConfig{
+			{Name: "east"},
+			{Name: "west"},
+		},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	registry, err := newGridEngineRegistry(ctx, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	seen := make(map[string]bool)
+
+	for _, family := range families {
+		for _, metric := range family.GetMetric() {
+			cluster := ""
+			for _, label := range metric.GetLabel() {
+				if label.GetName() == "cluster" {
+					cluster = label.GetValue()
+				}
+			}
+
+			if len(cluster) == 0 {
+				t.Fatalf("%s has no cluster label", family.GetName())
+			}
+
+			if family.GetName() == "sge_up" {
+				seen[cluster] = true
+			}
+		}
+	}
+
+	if !seen["east"] || !seen["west"] {
+		t.Errorf("sge_up reported for %v, want both east and west", seen)
+	}
+}
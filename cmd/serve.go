@@ -0,0 +1,180 @@
+package cmd
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+//reloadableHandler serves metrics from whichever registry was set most recently, so a reload can swap out every
+//collector without restarting the server
+type reloadableHandler struct {
+	mutex   sync.RWMutex
+	handler http.Handler
+}
+
+//Set starts serving metrics from the registry
+func (h *reloadableHandler) Set(registry *prometheus.Registry) {
+	handler := promhttp.InstrumentMetricHandler(registry, promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.handler = handler
+}
+
+func (h *reloadableHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mutex.RLock()
+	handler := h.handler
+	h.mutex.RUnlock()
+
+	handler.ServeHTTP(w, r)
+}
+
+//serve runs the exporter until it is asked to stop. SIGTERM and SIGINT drain in-flight scrapes for up to the shutdown
+//timeout before returning. SIGHUP reloads the configuration and rebuilds the collectors from it, carrying on with the
+//existing ones if the new configuration is invalid. The listen port and web config file can't be changed by a reload
+func serve(config Config) error {
+	//stop ends the background work of the collectors currently being served
+	ctx, stop := context.WithCancel(context.Background())
+	defer func() {
+		stop()
+	}()
+
+	webConfig := &WebConfig{}
+	if path := viper.GetString("web.config.file"); len(path) > 0 {
+		loaded, err := LoadWebConfig(path)
+		if err != nil {
+			return fmt.Errorf("unable to load the web config file %s: %w", path, err)
+		}
+		webConfig = loaded
+	}
+
+	registry, err := newRegistry(ctx, config)
+	if err != nil {
+		return err
+	}
+
+	handler := &reloadableHandler{}
+	handler.Set(registry)
+
+	probe := &probeHandler{}
+	probe.Set(config)
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", handler)
+	mux.Handle("/probe", probe)
+
+	server, err := newServer(fmt.Sprintf(":%d", viper.GetInt("port")), webConfig, mux)
+	if err != nil {
+		return err
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT, syscall.SIGHUP)
+	defer signal.Stop(signals)
+
+	errs := make(chan error, 1)
+
+	go func() {
+		log.Infof("Getting ready to start exporter on port %d", viper.GetInt("port"))
+		errs <- listen(server)
+	}()
+
+	for {
+		select {
+		case err := <-errs:
+			return fmt.Errorf("exporter stopped unexpectedly: %w", err)
+		case sig := <-signals:
+			if sig == syscall.SIGHUP {
+				reloaded, err := loadConfig()
+				if err != nil {
+					log.WithError(err).Error("Unable to reload configuration. Continuing with the existing configuration")
+					continue
+				}
+
+				//The old collectors are stopped first so the two never tail the accounting file at the same time
+				stop()
+				next, nextStop := context.WithCancel(context.Background())
+				stop = nextStop
+
+				registry, err := newRegistry(next, reloaded)
+				if err != nil {
+					log.WithError(err).Error("Unable to reload configuration. Continuing with the existing configuration")
+
+					//The old collectors have already been stopped, so bring them back as they were
+					if registry, err = newRegistry(next, config); err != nil {
+						return err
+					}
+
+					handler.Set(registry)
+					continue
+				}
+
+				handler.Set(registry)
+				probe.Set(reloaded)
+				config = reloaded
+
+				log.Info("Configuration reloaded")
+				continue
+			}
+
+			log.Infof("Received %s. Waiting up to %s for in-flight scrapes to finish", sig, config.ShutdownTimeout)
+
+			return shutdown(server, config)
+		}
+	}
+}
+
+//newServer creates the server for the metrics endpoint, secured as set out in the web config
+func newServer(addr string, webConfig *WebConfig, handler http.Handler) (*http.Server, error) {
+	tlsConfig, err := webConfig.TLSConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	server := &http.Server{
+		Addr:      addr,
+		Handler:   webConfig.Authenticate(handler),
+		TLSConfig: tlsConfig,
+	}
+
+	//An empty rather than nil map stops net/http from enabling HTTP/2 on its own
+	if tlsConfig != nil && !webConfig.HTTPServerConfig.HTTP2 {
+		server.TLSNextProto = make(map[string]func(*http.Server, *tls.Conn, http.Handler))
+	}
+
+	return server, nil
+}
+
+//listen serves over TLS if the server has a TLS configuration and plain HTTP otherwise
+func listen(server *http.Server) error {
+	if server.TLSConfig != nil {
+		return server.ListenAndServeTLS("", "")
+	}
+
+	return server.ListenAndServe()
+}
+
+//shutdown stops accepting new scrapes and waits up to the shutdown timeout for in-flight ones to finish
+func shutdown(server *http.Server, config Config) error {
+	ctx, cancel := context.WithTimeout(context.Background(), config.ShutdownTimeout)
+	defer cancel()
+
+	if err := server.Shutdown(ctx); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("unable to drain in-flight scrapes: %w", err)
+	}
+
+	return nil
+}
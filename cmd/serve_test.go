@@ -0,0 +1,42 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestReloadableHandler(t *testing.T) {
+	registry := func(name string) *prometheus.Registry {
+		r := prometheus.NewRegistry()
+		r.MustRegister(prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: name,
+			Help: "Test gauge",
+		}))
+		return r
+	}
+
+	scrape := func(h *reloadableHandler) string {
+		recorder := httptest.NewRecorder()
+		h.ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+		body, _ := ioutil.ReadAll(recorder.Body)
+		return string(body)
+	}
+
+	handler := &reloadableHandler{}
+	handler.Set(registry("before_reload"))
+
+	if body := scrape(handler); !strings.Contains(body, "before_reload") {
+		t.Errorf("Expected metrics from the first registry, got %s", body)
+	}
+
+	handler.Set(registry("after_reload"))
+
+	body := scrape(handler)
+	if !strings.Contains(body, "after_reload") || strings.Contains(body, "before_reload") {
+		t.Errorf("Expected metrics from only the reloaded registry, got %s", body)
+	}
+}
@@ -1,7 +1,13 @@
 package main
 
-import "github.com/metrumresearchgroup/gridengine_prometheus/cmd"
+import (
+	"os"
+
+	"github.com/metrumresearchgroup/gridengine_prometheus/cmd"
+)
 
 func main(){
-	cmd.RootCmd.Execute()
-}
\ No newline at end of file
+	if err := cmd.RootCmd.Execute(); err != nil {
+		os.Exit(1)
+	}
+}
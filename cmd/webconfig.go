@@ -0,0 +1,195 @@
+package cmd
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+
+	"golang.org/x/crypto/bcrypt"
+	"gopkg.in/yaml.v2"
+)
+
+//WebConfig is the web config file used to secure the metrics endpoint. It follows the format of the Prometheus
+//exporter-toolkit so the same file can be shared with other exporters, but only supports the settings below. Anything
+//else in the file is rejected rather than silently ignored
+type WebConfig struct {
+	TLSServerConfig  *TLSServerConfig  `yaml:"tls_server_config"`
+	HTTPServerConfig HTTPServerConfig  `yaml:"http_server_config"`
+	BasicAuthUsers   map[string]string `yaml:"basic_auth_users"`
+}
+
+//TLSServerConfig is the certificate the endpoint is served with and, optionally, how clients must authenticate with
+//their own certificates. Relative paths are relative to the web config file
+type TLSServerConfig struct {
+	CertFile       string     `yaml:"cert_file"`
+	KeyFile        string     `yaml:"key_file"`
+	ClientAuthType string     `yaml:"client_auth_type"`
+	ClientCAFile   string     `yaml:"client_ca_file"`
+	MinVersion     TLSVersion `yaml:"min_version"`
+	MaxVersion     TLSVersion `yaml:"max_version"`
+}
+
+//HTTPServerConfig holds settings for the HTTP server itself
+type HTTPServerConfig struct {
+	HTTP2 bool `yaml:"http2"`
+}
+
+//TLSVersion is a TLS protocol version, written as TLS10 through TLS13 in the web config file
+type TLSVersion uint16
+
+var tlsVersions = map[string]TLSVersion{
+	"TLS10": tls.VersionTLS10,
+	"TLS11": tls.VersionTLS11,
+	"TLS12": tls.VersionTLS12,
+	"TLS13": tls.VersionTLS13,
+}
+
+//UnmarshalYAML reads the TLS version from its name
+func (v *TLSVersion) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var name string
+	if err := unmarshal(&name); err != nil {
+		return err
+	}
+
+	version, ok := tlsVersions[name]
+	if !ok {
+		return fmt.Errorf("unknown TLS version %q", name)
+	}
+
+	*v = version
+	return nil
+}
+
+var clientAuthTypes = map[string]tls.ClientAuthType{
+	"":                           tls.NoClientCert,
+	"NoClientCert":               tls.NoClientCert,
+	"RequestClientCert":          tls.RequestClientCert,
+	"RequireAnyClientCert":       tls.RequireAnyClientCert,
+	"VerifyClientCertIfGiven":    tls.VerifyClientCertIfGiven,
+	"RequireAndVerifyClientCert": tls.RequireAndVerifyClientCert,
+}
+
+//LoadWebConfig reads and validates the web config file at path
+func LoadWebConfig(path string) (*WebConfig, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &WebConfig{}
+	if err := yaml.UnmarshalStrict(content, config); err != nil {
+		return nil, fmt.Errorf("unable to parse the web config file: %w", err)
+	}
+
+	if tc := config.TLSServerConfig; tc != nil {
+		dir := filepath.Dir(path)
+		tc.CertFile = relativeTo(dir, tc.CertFile)
+		tc.KeyFile = relativeTo(dir, tc.KeyFile)
+		tc.ClientCAFile = relativeTo(dir, tc.ClientCAFile)
+	}
+
+	for user, hash := range config.BasicAuthUsers {
+		if _, err := bcrypt.Cost([]byte(hash)); err != nil {
+			return nil, fmt.Errorf("the password of basic auth user %s is not a bcrypt hash: %w", user, err)
+		}
+	}
+
+	//Building the TLS configuration up front makes sure the certificates can be loaded
+	if _, err := config.TLSConfig(); err != nil {
+		return nil, err
+	}
+
+	return config, nil
+}
+
+func relativeTo(dir string, path string) string {
+	if len(path) == 0 || filepath.IsAbs(path) {
+		return path
+	}
+
+	return filepath.Join(dir, path)
+}
+
+//TLSConfig builds the TLS configuration for the server. It is nil if the web config doesn't enable TLS
+func (c *WebConfig) TLSConfig() (*tls.Config, error) {
+	tc := c.TLSServerConfig
+	if tc == nil {
+		return nil, nil
+	}
+
+	if len(tc.CertFile) == 0 || len(tc.KeyFile) == 0 {
+		return nil, errors.New("both cert_file and key_file are required to enable TLS")
+	}
+
+	certificate, err := tls.LoadX509KeyPair(tc.CertFile, tc.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("unable to load the TLS certificate: %w", err)
+	}
+
+	clientAuth, ok := clientAuthTypes[tc.ClientAuthType]
+	if !ok {
+		return nil, fmt.Errorf("unknown client_auth_type %q", tc.ClientAuthType)
+	}
+
+	config := &tls.Config{
+		Certificates: []tls.Certificate{certificate},
+		ClientAuth:   clientAuth,
+		MinVersion:   tls.VersionTLS12,
+		MaxVersion:   uint16(tc.MaxVersion),
+	}
+
+	if tc.MinVersion != 0 {
+		config.MinVersion = uint16(tc.MinVersion)
+	}
+
+	if len(tc.ClientCAFile) > 0 {
+		ca, err := ioutil.ReadFile(tc.ClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read the client CA file: %w", err)
+		}
+
+		config.ClientCAs = x509.NewCertPool()
+		if !config.ClientCAs.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("no certificates could be read from the client CA file %s", tc.ClientCAFile)
+		}
+	} else if clientAuth == tls.VerifyClientCertIfGiven || clientAuth == tls.RequireAndVerifyClientCert {
+		return nil, fmt.Errorf("client_ca_file is required to verify client certificates with %s", tc.ClientAuthType)
+	}
+
+	return config, nil
+}
+
+//dummyHash is compared against when an unknown user tries to log in, so the response takes as long as it would for a
+//real user with the wrong password
+var dummyHash = []byte("$2a$10$490ZB7j/mTVHpHie2iVysOSPBZBklwjmZi707rn5kq7M7Ec20i30a")
+
+//Authenticate requires the credentials of one of the basic auth users before passing requests on to handler. Without
+//any basic auth users the handler is returned as is
+func (c *WebConfig) Authenticate(handler http.Handler) http.Handler {
+	if len(c.BasicAuthUsers) == 0 {
+		return handler
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, password, ok := r.BasicAuth()
+		if ok {
+			hash, known := c.BasicAuthUsers[user]
+			if !known {
+				hash = string(dummyHash)
+			}
+
+			matched := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+			if known && matched {
+				handler.ServeHTTP(w, r)
+				return
+			}
+		}
+
+		w.Header().Set("WWW-Authenticate", "Basic")
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+	})
+}
@@ -0,0 +1,281 @@
+package cmd
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+//scrapeHash is the bcrypt hash of "scrape"
+const scrapeHash = "$2a$04$fMJejTjQxJZuaq2PpAsOz.APxEWfFL.IRGDQV5Aj/o2WIlUX.DLg."
+
+//writeCertificate generates a self signed certificate for 127.0.0.1 that is good for both server and client auth,
+//writing the certificate and key into dir as name.crt and name.key
+func writeCertificate(t *testing.T, dir string, name string) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certPem := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPem := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+
+	if err := ioutil.WriteFile(filepath.Join(dir, name+".crt"), certPem, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, name+".key"), keyPem, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	certificate, err := tls.X509KeyPair(certPem, keyPem)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return certificate
+}
+
+func writeWebConfig(t *testing.T, dir string, content string) string {
+	path := filepath.Join(dir, "web.yml")
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadWebConfig(t *testing.T) {
+	dir := t.TempDir()
+	writeCertificate(t, dir, "server")
+
+	tests := []struct {
+		name    string
+		content string
+		wantTLS bool
+		wantErr bool
+	}{
+		{
+			name:    "Basic auth only",
+			content: "basic_auth_users:\n  prometheus: " + scrapeHash + "\n",
+			wantTLS: false,
+		},
+		{
+			name:    "TLS with paths relative to the config file",
+			content: "tls_server_config:\n  cert_file: server.crt\n  key_file: server.key\n  min_version: TLS13\n",
+			wantTLS: true,
+		},
+		{
+			name:    "Client certificates",
+			content: "tls_server_config:\n  cert_file: server.crt\n  key_file: server.key\n  client_auth_type: RequireAndVerifyClientCert\n  client_ca_file: server.crt\n",
+			wantTLS: true,
+		},
+		{
+			name:    "Verifying client certificates needs a CA",
+			content: "tls_server_config:\n  cert_file: server.crt\n  key_file: server.key\n  client_auth_type: RequireAndVerifyClientCert\n",
+			wantErr: true,
+		},
+		{
+			name:    "Missing key",
+			content: "tls_server_config:\n  cert_file: server.crt\n",
+			wantErr: true,
+		},
+		{
+			name:    "Unknown TLS version",
+			content: "tls_server_config:\n  cert_file: server.crt\n  key_file: server.key\n  min_version: SSL3\n",
+			wantErr: true,
+		},
+		{
+			name:    "Plain text password",
+			content: "basic_auth_users:\n  prometheus: scrape\n",
+			wantErr: true,
+		},
+		{
+			name:    "Unsupported setting",
+			content: "tls_server_config:\n  cert_file: server.crt\n  key_file: server.key\n  cipher_suites: [TLS_AES_128_GCM_SHA256]\n",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, err := LoadWebConfig(writeWebConfig(t, dir, tt.content))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("LoadWebConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			tlsConfig, _ := config.TLSConfig()
+			if (tlsConfig != nil) != tt.wantTLS {
+				t.Errorf("TLSConfig() = %v, want TLS %v", tlsConfig, tt.wantTLS)
+			}
+		})
+	}
+}
+
+func TestNewServer(t *testing.T) {
+	dir := t.TempDir()
+	serverCert := writeCertificate(t, dir, "server")
+	clientCert := writeCertificate(t, dir, "client")
+
+	leaf, err := x509.ParseCertificate(serverCert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	roots := x509.NewCertPool()
+	roots.AddCert(leaf)
+
+	tests := []struct {
+		name       string
+		content    string
+		clientCert bool
+		user       string
+		password   string
+		wantStatus int
+		wantErr    bool
+	}{
+		{
+			name:       "Basic auth without credentials",
+			content:    "tls_server_config:\n  cert_file: server.crt\n  key_file: server.key\nbasic_auth_users:\n  prometheus: " + scrapeHash + "\n",
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "Basic auth with the wrong password",
+			content:    "tls_server_config:\n  cert_file: server.crt\n  key_file: server.key\nbasic_auth_users:\n  prometheus: " + scrapeHash + "\n",
+			user:       "prometheus",
+			password:   "wrong",
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "Basic auth with an unknown user",
+			content:    "tls_server_config:\n  cert_file: server.crt\n  key_file: server.key\nbasic_auth_users:\n  prometheus: " + scrapeHash + "\n",
+			user:       "grafana",
+			password:   "scrape",
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "Basic auth with the right password",
+			content:    "tls_server_config:\n  cert_file: server.crt\n  key_file: server.key\nbasic_auth_users:\n  prometheus: " + scrapeHash + "\n",
+			user:       "prometheus",
+			password:   "scrape",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:    "Client certificate missing",
+			content: "tls_server_config:\n  cert_file: server.crt\n  key_file: server.key\n  client_auth_type: RequireAndVerifyClientCert\n  client_ca_file: client.crt\n",
+			wantErr: true,
+		},
+		{
+			name:       "Client certificate provided",
+			content:    "tls_server_config:\n  cert_file: server.crt\n  key_file: server.key\n  client_auth_type: RequireAndVerifyClientCert\n  client_ca_file: client.crt\n",
+			clientCert: true,
+			wantStatus: http.StatusOK,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, err := LoadWebConfig(writeWebConfig(t, dir, tt.content))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			})
+
+			server, err := newServer(freeAddress(t), config, ok)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			go listen(server)
+			defer server.Close()
+
+			clientTLS := &tls.Config{RootCAs: roots}
+			if tt.clientCert {
+				clientTLS.Certificates = []tls.Certificate{clientCert}
+			}
+			client := &http.Client{Transport: &http.Transport{TLSClientConfig: clientTLS}}
+
+			request, _ := http.NewRequest("GET", "https://"+server.Addr+"/metrics", nil)
+			if len(tt.user) > 0 {
+				request.SetBasicAuth(tt.user, tt.password)
+			}
+
+			response, err := waitFor(client, request)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Scrape error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			defer response.Body.Close()
+
+			if response.StatusCode != tt.wantStatus {
+				t.Errorf("Scrape status = %d, want %d", response.StatusCode, tt.wantStatus)
+			}
+		})
+	}
+}
+
+//freeAddress finds a local port that nothing is listening on
+func freeAddress(t *testing.T) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	return listener.Addr().String()
+}
+
+//waitFor retries the request while the server is still starting up
+func waitFor(client *http.Client, request *http.Request) (*http.Response, error) {
+	var err error
+
+	for i := 0; i < 50; i++ {
+		var conn net.Conn
+		conn, err = net.Dial("tcp", request.URL.Host)
+		if err == nil {
+			conn.Close()
+			return client.Do(request)
+		}
+
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	return nil, err
+}
@@ -0,0 +1,284 @@
+package gridengine_prometheus
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/metrumresearchgroup/gogridengine"
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+//Job lifecycle events, counted by comparing consecutive snapshots
+const (
+	JobStarted  string = "started"
+	JobFinished string = "finished"
+	JobErrored  string = "errored"
+)
+
+//trackedJob is what is remembered about a single task of a job from one snapshot to the next
+type trackedJob struct {
+	Owner   string `json:"owner"`
+	Queue   string `json:"queue"`
+	Running bool   `json:"running"`
+	Errored bool   `json:"errored"`
+}
+
+//jobEventKey is the bounded set of labels events are counted by
+type jobEventKey struct {
+	Event string
+	Owner string
+	Queue string
+}
+
+//jobEventCount is a single count as it is persisted
+type jobEventCount struct {
+	Event string  `json:"event"`
+	Owner string  `json:"owner"`
+	Queue string  `json:"queue"`
+	Value float64 `json:"value"`
+}
+
+//jobEventState is what we persist between runs, so that a restart carries on comparing against the last snapshot
+//rather than counting every job that is running as having just started, and the counts carry on from where they were
+type jobEventState struct {
+	Jobs   map[string]trackedJob `json:"jobs"`
+	Counts []jobEventCount       `json:"counts"`
+}
+
+//JobEventCollector counts jobs starting, finishing and entering an error state by comparing each qstat snapshot with
+//the one before it. Anything that happens entirely between two snapshots, such as a job that starts and finishes
+//within a poll interval, is never seen, so the accounting collector remains the authority on finished jobs
+type JobEventCollector struct {
+	Started  *prometheus.Desc
+	Finished *prometheus.Desc
+	Errored  *prometheus.Desc
+
+	//StatePath is where the jobs of the last snapshot and the counts are persisted. Empty disables persistence
+	StatePath string
+
+	mutex  sync.Mutex
+	loaded bool
+	//jobs are the tasks of the last snapshot keyed by job number and task. nil until there is a snapshot to compare to
+	jobs   map[string]trackedJob
+	counts map[jobEventKey]float64
+}
+
+//NewJobEventCollector returns a collector counting job lifecycle events, with metric names prefixed by namespace. It
+//must be handed snapshots by a poller to count anything
+func NewJobEventCollector(namespace string, statePath string) *JobEventCollector {
+	name := func(metric string) string {
+		return prometheus.BuildFQName(namespace, "", metric)
+	}
+
+	labels := []string{
+		"owner",
+		"queue",
+	}
+
+	return &JobEventCollector{
+		Started: prometheus.NewDesc(
+			name("jobs_started_total"),
+			"Number of jobs seen to start running between snapshots, by owner and the queue they are running in",
+			labels,
+			nil),
+		Finished: prometheus.NewDesc(
+			name("jobs_finished_total"),
+			"Number of running jobs that were gone by the next snapshot, by owner and the queue they were running in",
+			labels,
+			nil),
+		Errored: prometheus.NewDesc(
+			name("jobs_errored_total"),
+			"Number of jobs seen to enter an error state between snapshots, by owner and queue",
+			labels,
+			nil),
+		StatePath: statePath,
+		counts:    make(map[jobEventKey]float64),
+	}
+}
+
+//Describe provides prometheus with descriptions and details (not values) of each metric
+func (collector *JobEventCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- collector.Started
+	ch <- collector.Finished
+	ch <- collector.Errored
+}
+
+//Collect feeds the counts into the channel
+func (collector *JobEventCollector) Collect(ch chan<- prometheus.Metric) {
+	collector.mutex.Lock()
+	defer collector.mutex.Unlock()
+
+	descs := map[string]*prometheus.Desc{
+		JobStarted:  collector.Started,
+		JobFinished: collector.Finished,
+		JobErrored:  collector.Errored,
+	}
+
+	for key, value := range collector.counts {
+		ch <- prometheus.MustNewConstMetric(descs[key.Event], prometheus.CounterValue, value, key.Owner, key.Queue)
+	}
+}
+
+//Observe counts the events between the previous snapshot and this one, then persists the state. The first snapshot
+//without any persisted state is only remembered, rather than counting every job on the cluster as having just started.
+//The snapshot must have been taken by a poller handing its snapshots over, which tracks the jobs as they are decoded
+func (collector *JobEventCollector) Observe(snapshot *Snapshot) error {
+	current := snapshot.tracked
+	if current == nil {
+		return errors.New("the jobs of the snapshot weren't tracked")
+	}
+
+	collector.mutex.Lock()
+	defer collector.mutex.Unlock()
+
+	if !collector.loaded {
+		if err := collector.loadState(); err != nil {
+			log.WithError(err).Warn("Unable to load the job event state. Starting again from this snapshot")
+		}
+		collector.loaded = true
+	}
+
+	if collector.jobs != nil {
+		for key, job := range current {
+			previous, seen := collector.jobs[key]
+
+			if job.Running && !(seen && previous.Running) {
+				collector.counts[jobEventKey{Event: JobStarted, Owner: job.Owner, Queue: job.Queue}]++
+			}
+
+			if job.Errored && !(seen && previous.Errored) {
+				collector.counts[jobEventKey{Event: JobErrored, Owner: job.Owner, Queue: job.Queue}]++
+			}
+		}
+
+		for key, job := range collector.jobs {
+			if _, ok := current[key]; !ok && job.Running {
+				collector.counts[jobEventKey{Event: JobFinished, Owner: job.Owner, Queue: job.Queue}]++
+			}
+		}
+	}
+
+	collector.jobs = current
+
+	return collector.saveState()
+}
+
+//trackJobs picks out every task of every job in the snapshot as it is decoded. A parallel job is listed under each
+//queue instance it runs on, and is tracked under the first of them
+func trackJobs(snapshot *Snapshot) QstatVisitor {
+	jobs := make(map[string]trackedJob)
+	snapshot.tracked = jobs
+
+	track := func(j Job, queue string) {
+		key := j.task().String()
+
+		if _, ok := jobs[key]; ok {
+			return
+		}
+
+		jobs[key] = trackedJob{
+			Owner:   j.JobOwner,
+			Queue:   queue,
+			Running: gogridengine.IsJobRunning(j.Job) == 1,
+			Errored: gogridengine.IsJobInErrorState(j.Job) == 1,
+		}
+	}
+
+	return QstatVisitor{
+		Queue: func(instance QueueInstance) {
+			//A malformed name still has its jobs tracked, so they aren't counted as finished because of it
+			queue, _, _ := queueInstanceLabels(instance.Name, false)
+
+			for _, j := range instance.Jobs {
+				track(j, queue)
+			}
+		},
+		PendingJob: func(j Job) {
+			track(j, pendingQueue)
+		},
+	}
+}
+
+func (collector *JobEventCollector) loadState() error {
+	if len(collector.StatePath) == 0 {
+		return nil
+	}
+
+	content, err := ioutil.ReadFile(collector.StatePath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	state := jobEventState{}
+	if err := json.Unmarshal(content, &state); err != nil {
+		return err
+	}
+
+	collector.jobs = state.Jobs
+
+	for _, count := range state.Counts {
+		if count.Event != JobStarted && count.Event != JobFinished && count.Event != JobErrored {
+			continue
+		}
+
+		collector.counts[jobEventKey{Event: count.Event, Owner: count.Owner, Queue: count.Queue}] = count.Value
+	}
+
+	return nil
+}
+
+//saveState persists the jobs of the last snapshot and the counts, writing to a temporary file first so a crash never
+//leaves a half written state behind
+func (collector *JobEventCollector) saveState() error {
+	if len(collector.StatePath) == 0 {
+		return nil
+	}
+
+	state := jobEventState{
+		Jobs:   collector.jobs,
+		Counts: make([]jobEventCount, 0, len(collector.counts)),
+	}
+
+	for key, value := range collector.counts {
+		state.Counts = append(state.Counts, jobEventCount{
+			Event: key.Event,
+			Owner: key.Owner,
+			Queue: key.Queue,
+			Value: value,
+		})
+	}
+
+	//Sorted so the file only changes when the counts do
+	sort.Slice(state.Counts, func(i, j int) bool {
+		a, b := state.Counts[i], state.Counts[j]
+		if a.Event != b.Event {
+			return a.Event < b.Event
+		}
+		if a.Owner != b.Owner {
+			return a.Owner < b.Owner
+		}
+		return a.Queue < b.Queue
+	})
+
+	content, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	tmp := collector.StatePath + ".tmp"
+	if err := ioutil.WriteFile(tmp, content, 0644); err != nil {
+		return fmt.Errorf("unable to write job event state: %w", err)
+	}
+
+	return os.Rename(tmp, collector.StatePath)
+}
@@ -0,0 +1,202 @@
+package gridengine_prometheus
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+//eventJob is a single task as qstat lists it. An empty queue lists it as pending
+type eventJob struct {
+	number int
+	task   int
+	owner  string
+	state  string
+	queue  string
+}
+
+//eventSnapshot takes a snapshot of qstat XML listing the jobs, with each running job in a queue instance of its own
+func eventSnapshot(t *testing.T, jobs ...eventJob) *Snapshot {
+	xml := func(j eventJob) string {
+		tasks := ""
+		if j.task > 0 {
+			tasks = fmt.Sprintf("<tasks>%d</tasks>", j.task)
+		}
+		return fmt.Sprintf("<job_list><JB_job_number>%d</JB_job_number><JB_owner>%s</JB_owner><state>%s</state><slots>1</slots>%s</job_list>", j.number, j.owner, j.state, tasks)
+	}
+
+	var queues, pending strings.Builder
+
+	for i, j := range jobs {
+		if len(j.queue) == 0 {
+			pending.WriteString(xml(j))
+			continue
+		}
+		fmt.Fprintf(&queues, "<Queue-List><name>%s@node%d</name><slots_total>1</slots_total>%s</Queue-List>", j.queue, i, xml(j))
+	}
+
+	payload := "<job_info><queue_info>" + queues.String() + "</queue_info><job_info>" + pending.String() + "</job_info></job_info>"
+
+	snapshot, err := takeSnapshot(SourceFunc(func() (string, error) {
+		return payload, nil
+	}), trackJobs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return snapshot
+}
+
+func TestJobEventCollector_Observe(t *testing.T) {
+	const header = `
+# HELP sge_jobs_errored_total Number of jobs seen to enter an error state between snapshots, by owner and queue
+# TYPE sge_jobs_errored_total counter
+# HELP sge_jobs_finished_total Number of running jobs that were gone by the next snapshot, by owner and the queue they were running in
+# TYPE sge_jobs_finished_total counter
+# HELP sge_jobs_started_total Number of jobs seen to start running between snapshots, by owner and the queue they are running in
+# TYPE sge_jobs_started_total counter
+`
+
+	tests := []struct {
+		name      string
+		snapshots [][]eventJob
+		want      string
+	}{
+		{
+			name: "First snapshot is only remembered",
+			snapshots: [][]eventJob{
+				{{number: 1, owner: "jdoe", state: "r", queue: "all.q"}, {number: 2, owner: "jdoe", state: "Eqw"}},
+			},
+			want: ``,
+		},
+		{
+			name: "Pending job starts and finishes",
+			snapshots: [][]eventJob{
+				{{number: 1, owner: "jdoe", state: "qw"}},
+				{{number: 1, owner: "jdoe", state: "r", queue: "all.q"}},
+				{{number: 1, owner: "jdoe", state: "r", queue: "all.q"}},
+				{},
+			},
+			want: `
+sge_jobs_finished_total{owner="jdoe",queue="all.q"} 1
+sge_jobs_started_total{owner="jdoe",queue="all.q"} 1
+`,
+		},
+		{
+			name: "Job submitted and started between snapshots",
+			snapshots: [][]eventJob{
+				{},
+				{{number: 1, owner: "jdoe", state: "r", queue: "gpu.q"}},
+			},
+			want: `
+sge_jobs_started_total{owner="jdoe",queue="gpu.q"} 1
+`,
+		},
+		{
+			name: "Pending job deleted",
+			snapshots: [][]eventJob{
+				{{number: 1, owner: "jdoe", state: "qw"}},
+				{},
+			},
+			want: ``,
+		},
+		{
+			name: "Job enters an error state once",
+			snapshots: [][]eventJob{
+				{{number: 1, owner: "asmith", state: "qw"}},
+				{{number: 1, owner: "asmith", state: "Eqw"}},
+				{{number: 1, owner: "asmith", state: "Eqw"}},
+			},
+			want: `
+sge_jobs_errored_total{owner="asmith",queue="pending"} 1
+`,
+		},
+		{
+			name: "Array tasks are counted separately",
+			snapshots: [][]eventJob{
+				{{number: 1, owner: "jdoe", state: "qw"}},
+				{{number: 1, task: 1, owner: "jdoe", state: "r", queue: "all.q"}, {number: 1, task: 2, owner: "jdoe", state: "r", queue: "all.q"}, {number: 1, owner: "jdoe", state: "qw"}},
+				{{number: 1, task: 2, owner: "jdoe", state: "r", queue: "all.q"}},
+			},
+			want: `
+sge_jobs_finished_total{owner="jdoe",queue="all.q"} 1
+sge_jobs_started_total{owner="jdoe",queue="all.q"} 2
+`,
+		},
+		{
+			name: "Parallel job is counted once",
+			snapshots: [][]eventJob{
+				{},
+				{{number: 1, owner: "jdoe", state: "r", queue: "all.q"}, {number: 1, owner: "jdoe", state: "r", queue: "all.q"}},
+				{},
+			},
+			want: `
+sge_jobs_finished_total{owner="jdoe",queue="all.q"} 1
+sge_jobs_started_total{owner="jdoe",queue="all.q"} 1
+`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			collector := NewJobEventCollector(DefaultNamespace, "")
+
+			for _, jobs := range tt.snapshots {
+				if err := collector.Observe(eventSnapshot(t, jobs...)); err != nil {
+					t.Fatalf("Observe() error = %v", err)
+				}
+			}
+
+			if err := testutil.CollectAndCompare(collector, strings.NewReader(header+tt.want)); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestJobEventCollector_State(t *testing.T) {
+	statePath := filepath.Join(t.TempDir(), "job_events.json")
+
+	running := eventJob{number: 1, owner: "jdoe", state: "r", queue: "all.q"}
+	started := eventJob{number: 2, owner: "jdoe", state: "r", queue: "all.q"}
+
+	p := NewPoller(time.Minute)
+	p.Events = NewJobEventCollector(DefaultNamespace, statePath)
+	p.Source = SourceFunc(func() (string, error) {
+		return "<job_info><queue_info></queue_info><job_info></job_info></job_info>", nil
+	})
+
+	//The poller hands its snapshot over, so the running job below is counted as having started
+	if err := p.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := p.Events.Observe(eventSnapshot(t, running)); err != nil {
+		t.Fatal(err)
+	}
+
+	//A restart carries on from the last snapshot, so the job that was already running isn't counted again
+	restarted := NewJobEventCollector(DefaultNamespace, statePath)
+
+	if err := restarted.Observe(eventSnapshot(t, running, started)); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := testutil.ToFloat64(restarted); got != 2 {
+		t.Errorf("jobs started after a restart = %v, want 2", got)
+	}
+
+	//Without the state, the first snapshot is only remembered
+	fresh := NewJobEventCollector(DefaultNamespace, filepath.Join(t.TempDir(), "job_events.json"))
+
+	if err := fresh.Observe(eventSnapshot(t, running, started)); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := testutil.CollectAndCount(fresh); got != 0 {
+		t.Errorf("events counted from the first snapshot = %d, want 0", got)
+	}
+}
@@ -1,10 +1,12 @@
 package gridengine_prometheus
 
 import (
-	"encoding/xml"
+	"errors"
+	"math"
 	"os"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/metrumresearchgroup/gogridengine"
 	"github.com/prometheus/client_golang/prometheus"
@@ -22,14 +24,69 @@ type GridEngine struct {
 	UsedMemory     *prometheus.Desc
 	TotalMemory    *prometheus.Desc
 	CPUUtilization *prometheus.Desc
+	ResourceValue  *prometheus.Desc
 	//Job Details
 	JobState    *prometheus.Desc
 	JobPriority *prometheus.Desc
 	JobSlots    *prometheus.Desc
 	JobErrors   *prometheus.Desc
+	JobSubmit   *prometheus.Desc
+	JobStart    *prometheus.Desc
+	PendingWait *prometheus.Desc
+	//Job Requests
+	JobMemoryRequest  *prometheus.Desc
+	JobRuntimeRequest *prometheus.Desc
+	JobPESlots        *prometheus.Desc
+	//Job Aggregates
+	Jobs               *prometheus.Desc
+	JobSlotsTotal      *prometheus.Desc
+	JobsMemoryRequest  *prometheus.Desc
+	JobsRuntimeRequest *prometheus.Desc
+	JobsPESlots        *prometheus.Desc
+	//Queue Instance Details
+	QueueState *prometheus.Desc
+	//Snapshot Details
+	SnapshotAge     *prometheus.Desc
+	RefreshDuration *prometheus.Desc
+	//Exporter Health
+	Up             *prometheus.Desc
+	ScrapeDuration *prometheus.Desc
+	QstatErrors    *prometheus.Desc
+	QstatTimeouts  *prometheus.Desc
+	QstatRetries   *prometheus.Desc
+	LastSuccess    *prometheus.Desc
+
+	//ShortHostnames strips the domain from hostnames taken from queue instance names
+	ShortHostnames bool
+	//DisableJobSeries turns off the per job metrics, leaving only the aggregates
+	DisableJobSeries bool
+	//MaxJobSeries caps the per job metrics to this many of the highest priority jobs. 0 is unlimited
+	MaxJobSeries int
+	//Resources selects which resources are reported by ResourceValue
+	Resources ResourceFilter
+	//MasterHostname is the hostname pending jobs are reported under, as they haven't been scheduled onto a host yet
+	MasterHostname string
+
+	//Poller supplies the qstat snapshot. NewGridEngine sets up one polling the source of its options
+	Poller *Poller
+
+	//legacy maps descriptions to their original names when legacy names are enabled
+	legacy map[*prometheus.Desc]*prometheus.Desc
 }
 
-func NewGridEngine() *GridEngine {
+func NewGridEngine(options ...Option) *GridEngine {
+	o := gridEngineOptions{
+		namespace: DefaultNamespace,
+		source:    QstatSource{},
+	}
+
+	for _, option := range options {
+		option(&o)
+	}
+
+	name := func(metric string) string {
+		return prometheus.BuildFQName(o.namespace, "", metric)
+	}
 
 	hostLabels := []string{
 		"hostname",
@@ -46,68 +103,194 @@ func NewGridEngine() *GridEngine {
 		"state",
 	}
 
-	return &GridEngine{
+	collector := &GridEngine{
 		TotalSlots: prometheus.NewDesc(
-			"total_slots_count",
+			name("queue_slots"),
 			"Total Number of slots available to the host",
 			hostLabels,
 			nil),
 		UsedSlots: prometheus.NewDesc(
-			"used_slots_count",
+			name("queue_slots_used"),
 			"Number of used slots on host",
 			hostLabels,
 			nil),
 		ReservedSlots: prometheus.NewDesc(
-			"reserved_slots_count",
+			name("queue_slots_reserved"),
 			"Number of reserved slots on host",
 			hostLabels,
 			nil),
 		LoadAverage: prometheus.NewDesc(
-			"sge_load_average",
+			name("load_average"),
 			"Load average of this specific SGE host",
 			hostLabels,
 			nil),
 		FreeMemory: prometheus.NewDesc(
-			"free_memory_bytes",
+			name("free_memory_bytes"),
 			"Number of bytes in free memory",
 			hostLabels,
 			nil),
 		UsedMemory: prometheus.NewDesc(
-			"sge_used_memory_bytes",
+			name("used_memory_bytes"),
 			"Number of bytes in used memory",
 			hostLabels,
 			nil),
 		TotalMemory: prometheus.NewDesc(
-			"sge_total_memory_bytes",
+			name("total_memory_bytes"),
 			"Number of bytes in total memory",
 			hostLabels,
 			nil),
 		CPUUtilization: prometheus.NewDesc(
-			"sge_cpu_utilization_percent",
+			name("cpu_utilization_percent"),
 			"Decimal representing total CPU utilization on host",
 			hostLabels,
 			nil),
+		ResourceValue: prometheus.NewDesc(
+			name("resource_value"),
+			"Value of a numeric resource from the resource list of the queue instance, by resource and qstat resource type",
+			[]string{"hostname", "queue", "resource", "type"},
+			nil),
 		JobState: prometheus.NewDesc(
-			"job_state_value",
+			name("job_running"),
 			"Indicates whether job is running (1) or not (0)",
 			jobLabels,
 			nil),
 		JobPriority: prometheus.NewDesc(
-			"job_priority_value",
+			name("job_priority"),
 			"Qstat priority for given job",
 			jobLabels,
 			nil),
 		JobSlots: prometheus.NewDesc(
-			"job_slots_count",
+			name("job_slots_requested"),
 			"Number of slots on the selected job",
 			jobLabels,
 			nil),
 		JobErrors: prometheus.NewDesc(
-			"job_errors",
+			name("job_error_state"),
 			"Jobs that are reported in an errored or anomalous state",
 			jobLabels,
 			nil),
+		JobSubmit: prometheus.NewDesc(
+			name("job_submit_timestamp_seconds"),
+			"Unix timestamp of when a pending job was submitted",
+			jobLabels,
+			nil),
+		JobStart: prometheus.NewDesc(
+			name("job_start_timestamp_seconds"),
+			"Unix timestamp of when a running job started",
+			jobLabels,
+			nil),
+		PendingWait: prometheus.NewDesc(
+			name("pending_job_wait_seconds"),
+			"How long currently pending jobs have been waiting since submission, by requested queue",
+			[]string{"queue"},
+			nil),
+		JobMemoryRequest: prometheus.NewDesc(
+			name("job_memory_request_bytes"),
+			"Memory requested per slot by the job, by resource",
+			append(jobLabels, "resource"),
+			nil),
+		JobRuntimeRequest: prometheus.NewDesc(
+			name("job_runtime_request_seconds"),
+			"Run time limit (h_rt) requested by the job",
+			jobLabels,
+			nil),
+		JobPESlots: prometheus.NewDesc(
+			name("job_parallel_environment_slots"),
+			"Number of slots granted to, or at least requested by, the job from its parallel environment",
+			append(jobLabels, "pe"),
+			nil),
+		Jobs: prometheus.NewDesc(
+			name("jobs"),
+			"Number of jobs by owner, queue and state",
+			[]string{"owner", "queue", "state"},
+			nil),
+		JobSlotsTotal: prometheus.NewDesc(
+			name("job_slots"),
+			"Number of slots used or requested by jobs by owner, queue and state",
+			[]string{"owner", "queue", "state"},
+			nil),
+		JobsMemoryRequest: prometheus.NewDesc(
+			name("jobs_memory_request_bytes"),
+			"Memory requested across every slot of jobs by owner, queue, state and resource",
+			[]string{"owner", "queue", "state", "resource"},
+			nil),
+		JobsRuntimeRequest: prometheus.NewDesc(
+			name("jobs_runtime_request_seconds"),
+			"Sum of the run time limits (h_rt) requested by jobs by owner, queue and state",
+			[]string{"owner", "queue", "state"},
+			nil),
+		JobsPESlots: prometheus.NewDesc(
+			name("jobs_parallel_environment_slots"),
+			"Slots granted to, or at least requested by, jobs from each parallel environment by owner, queue and state",
+			[]string{"owner", "queue", "state", "pe"},
+			nil),
+		QueueState: prometheus.NewDesc(
+			name("queue_instance_state"),
+			"Whether the queue instance is currently in the given state (1) or not (0)",
+			[]string{"hostname", "queue", "state"},
+			nil),
+		SnapshotAge: prometheus.NewDesc(
+			name("snapshot_age_seconds"),
+			"Number of seconds since the qstat snapshot being reported was taken",
+			nil,
+			nil),
+		RefreshDuration: prometheus.NewDesc(
+			name("snapshot_refresh_duration_seconds"),
+			"Number of seconds it took to run and parse qstat for the snapshot being reported",
+			nil,
+			nil),
+		Up: prometheus.NewDesc(
+			name("up"),
+			"Whether the most recent attempt to gather qstat details succeeded (1) or not (0)",
+			nil,
+			nil),
+		ScrapeDuration: prometheus.NewDesc(
+			name("scrape_duration_seconds"),
+			"Number of seconds spent collecting grid engine metrics for this scrape",
+			nil,
+			nil),
+		QstatErrors: prometheus.NewDesc(
+			name("qstat_errors_total"),
+			"Number of failures gathering qstat details by the stage at which they failed",
+			[]string{"stage"},
+			nil),
+		QstatTimeouts: prometheus.NewDesc(
+			name("qstat_timeouts_total"),
+			"Number of times qstat was killed for running longer than the exec timeout",
+			nil,
+			nil),
+		QstatRetries: prometheus.NewDesc(
+			name("qstat_retries_total"),
+			"Number of times qstat was run again after failing to run",
+			nil,
+			nil),
+		LastSuccess: prometheus.NewDesc(
+			name("last_success_timestamp_seconds"),
+			"Unix timestamp of the most recent successful qstat snapshot",
+			nil,
+			nil),
+	}
+	collector.MasterHostname = o.masterHostname
+	if len(collector.MasterHostname) == 0 {
+		//Process the hostname as the master
+		hostname, err := os.Hostname()
+		if err != nil {
+			hostname = "localhost"
+		}
+		collector.MasterHostname = hostname
+	}
+
+	if o.legacyNames {
+		collector.legacy = legacyDescs(collector, name, hostLabels, jobLabels)
 	}
+
+	collector.Poller = NewPoller(o.pollInterval)
+	collector.Poller.Collector = collector
+	collector.Poller.Source = o.source
+	collector.Poller.Retries = o.retries
+	collector.Poller.Backoff = o.backoff
+
+	return collector
 }
 
 //Describe provides prometheus with descriptions and details (not values) of each metric
@@ -121,110 +304,244 @@ func (collector *GridEngine) Describe(ch chan<- *prometheus.Desc) {
 	ch <- collector.UsedMemory
 	ch <- collector.TotalMemory
 	ch <- collector.CPUUtilization
+	ch <- collector.ResourceValue
 	//Job Components -> Additional Labels for identification
 	ch <- collector.JobState
 	ch <- collector.JobPriority
 	ch <- collector.JobSlots
+	ch <- collector.JobErrors
+	ch <- collector.JobSubmit
+	ch <- collector.JobStart
+	ch <- collector.PendingWait
+	//Job Requests
+	ch <- collector.JobMemoryRequest
+	ch <- collector.JobRuntimeRequest
+	ch <- collector.JobPESlots
+	//Job Aggregates
+	ch <- collector.Jobs
+	ch <- collector.JobSlotsTotal
+	ch <- collector.JobsMemoryRequest
+	ch <- collector.JobsRuntimeRequest
+	ch <- collector.JobsPESlots
+	//Queue Instance Details
+	ch <- collector.QueueState
+	//Snapshot Details
+	ch <- collector.SnapshotAge
+	ch <- collector.RefreshDuration
+	//Exporter Health
+	ch <- collector.Up
+	ch <- collector.ScrapeDuration
+	ch <- collector.QstatErrors
+	ch <- collector.QstatTimeouts
+	ch <- collector.QstatRetries
+	ch <- collector.LastSuccess
+	//Legacy Names
+	for _, legacy := range collector.legacy {
+		ch <- legacy
+	}
 }
 
 //Collect does all the work of actually generating and feeding metrics into the channel
 func (collector *GridEngine) Collect(ch chan<- prometheus.Metric) {
+	start := time.Now()
+	poller := collector.Poller
+
+	//Health is reported regardless of whether we have anything else to report
+	defer func() {
+		collector.collectHealth(ch, poller)
+		ch <- prometheus.MustNewConstMetric(collector.ScrapeDuration, prometheus.GaugeValue, time.Since(start).Seconds())
+	}()
 
-	//How to get the XML String
-	x, err := gogridengine.GetQstatOutput(make(map[string]string))
+	snapshot, err := collector.snapshot(poller)
 	if err != nil {
-		log.WithError(err).Error("There was an error processing the XML output")
+		log.WithError(err).Error("Unable to retrieve a qstat snapshot")
 		return
 	}
 
-	ji := gogridengine.JobInfo{}
+	ch <- prometheus.MustNewConstMetric(collector.SnapshotAge, prometheus.GaugeValue, time.Since(snapshot.Timestamp).Seconds())
+	ch <- prometheus.MustNewConstMetric(collector.RefreshDuration, prometheus.GaugeValue, snapshot.Duration.Seconds())
+
+	//Now to begin iterating over the queue instances
+	for _, instance := range snapshot.Queues {
+		queue, hostname, err := queueInstanceLabels(instance.Name, collector.ShortHostnames)
+		if err != nil {
+			log.WithError(err).Errorf("Queue instance has a malformed name %q", instance.Name)
+			poller.RecordError(StageQueueName)
+		}
 
-	err = xml.Unmarshal([]byte(x), &ji)
+		collector.emit(ch, collector.UsedSlots, float64(instance.SlotsUsed), hostname, queue)
+		collector.emit(ch, collector.ReservedSlots, float64(instance.SlotsReserved), hostname, queue)
+		collector.emit(ch, collector.TotalSlots, float64(instance.SlotsTotal), hostname, queue)
+		collector.emit(ch, collector.LoadAverage, instance.LoadAverage, hostname, queue)
 
-	if err != nil {
-		log.WithError(err).Error("Unable to marshal the XML cleanly into an object")
+		for state, set := range QueueStateFlags(instance.State) {
+			value := 0.0
+			if set {
+				value = 1
+			}
+			ch <- prometheus.MustNewConstMetric(collector.QueueState, prometheus.GaugeValue, value, hostname, queue, state)
+		}
+
+		//Only hosts have memory and CPU load values. Cluster queues without one would only count as failures
+		if len(hostname) > 0 {
+			collector.collectHostLoad(ch, poller, instance, hostname, queue)
+		}
+
+		collector.collectResources(ch, instance, hostname, queue)
+	}
+
+	//Jobs are only summarized by the poller NewGridEngine sets up for the collector
+	summary := snapshot.summary
+	if summary == nil {
 		return
 	}
 
-	//Now to begin iterating over the QueueList components
-	for _, ql := range ji.QueueInfo.Queues {
-		//Assumes all.q@ip-172-16-2-102.us-west-2.compute.internal structure
-		pieces := strings.Split(ql.Name, "@")
-		queue := pieces[0]
-		hostname := pieces[1]
+	for queue, wait := range summary.waits {
+		ch <- prometheus.MustNewConstHistogram(collector.PendingWait, wait.count, wait.sum, wait.buckets, queue)
+	}
 
-		ch <- prometheus.MustNewConstMetric(collector.UsedSlots, prometheus.GaugeValue, float64(ql.SlotsUsed), hostname, queue)
-		ch <- prometheus.MustNewConstMetric(collector.ReservedSlots, prometheus.GaugeValue, float64(ql.SlotsReserved), hostname, queue)
-		ch <- prometheus.MustNewConstMetric(collector.TotalSlots, prometheus.GaugeValue, float64(ql.SlotsTotal), hostname, queue)
-		ch <- prometheus.MustNewConstMetric(collector.LoadAverage, prometheus.GaugeValue, ql.LoadAverage, hostname, queue)
+	collector.collectJobs(ch, summary)
+}
 
-		FreeMemory, err := ql.Resources.FreeMemory()
+//collectHostLoad emits the memory and CPU load values of the host of a queue instance, counting any that are missing
+//or can't be parsed. The resource list is decoded alongside gogridengine's types rather than by them, so the values are
+//parsed here, with memory converted into whole bytes as gogridengine did
+func (collector *GridEngine) collectHostLoad(ch chan<- prometheus.Metric, poller *Poller, instance QueueInstance, hostname string, queue string) {
+	parseFloat := func(value string) (float64, error) {
+		return strconv.ParseFloat(strings.TrimSpace(value), 64)
+	}
 
-		if err != nil {
-			log.WithError(err).Error("There was an error extracting Free Memory from the resource list")
-			FreeMemory = gogridengine.StorageValue{
-				Bytes: 0,
-			}
-		}
+	parseBytes := func(value string) (float64, error) {
+		bytes, err := ParseSize(value)
+		return math.Floor(bytes), err
+	}
 
-		ch <- prometheus.MustNewConstMetric(collector.FreeMemory, prometheus.GaugeValue, float64(FreeMemory.Bytes), hostname, queue)
+	loads := []struct {
+		desc     *prometheus.Desc
+		resource string
+		name     string
+		parse    func(value string) (float64, error)
+	}{
+		{collector.FreeMemory, "mem_free", "Free Memory", parseBytes},
+		{collector.UsedMemory, "mem_used", "Used Memory", parseBytes},
+		{collector.TotalMemory, "mem_total", "Total Memory", parseBytes},
+		{collector.CPUUtilization, "cpu", "CPU Utilization", parseFloat},
+	}
 
-		UsedMemory, err := ql.Resources.MemoryUsed()
+	for _, load := range loads {
+		value, err := instance.resource(load.resource, load.parse)
 
 		if err != nil {
-			log.WithError(err).Error("There was an error extracting Used Memory from the resource list")
-			UsedMemory = gogridengine.StorageValue{
-				Bytes: 0,
-			}
+			log.WithError(err).Errorf("There was an error extracting %s from the resource list", load.name)
+			poller.RecordError(StageResource)
+			value = 0
 		}
 
-		ch <- prometheus.MustNewConstMetric(collector.UsedMemory, prometheus.GaugeValue, float64(UsedMemory.Bytes), hostname, queue)
+		collector.emit(ch, load.desc, value, hostname, queue)
+	}
+}
 
-		TotalMemory, err := ql.Resources.TotalMemory()
+//PendingWaitBuckets are the upper bounds in seconds of the pending job wait histogram, from a minute to a week
+var PendingWaitBuckets = []float64{60, 300, 900, 1800, 3600, 7200, 14400, 28800, 86400, 172800, 604800}
 
-		if err != nil {
-			log.WithError(err).Error("There was an error extracting Total Memory from the resource list")
-			TotalMemory = gogridengine.StorageValue{
-				Bytes: 0,
-			}
-		}
+//pendingWait accumulates a histogram of pending job wait times for a single queue
+type pendingWait struct {
+	count   uint64
+	sum     float64
+	buckets map[float64]uint64
+}
 
-		ch <- prometheus.MustNewConstMetric(collector.TotalMemory, prometheus.GaugeValue, float64(TotalMemory.Bytes), hostname, queue)
+//pendingWaits are the histograms for each requested queue. Jobs that didn't request a queue are reported as "any"
+type pendingWaits map[string]*pendingWait
 
-		CPUUtilization, err := ql.Resources.CPU()
+func newPendingWaits() pendingWaits {
+	return make(pendingWaits)
+}
 
-		if err != nil {
-			log.WithError(err).Error("There was an error extracting CPU Utilization from the resource list")
-			CPUUtilization = 0
+func (w pendingWaits) observe(queue string, seconds float64) {
+	if len(queue) == 0 {
+		queue = "any"
+	}
+
+	wait, ok := w[queue]
+	if !ok {
+		wait = &pendingWait{
+			buckets: make(map[float64]uint64, len(PendingWaitBuckets)),
+		}
+		for _, bound := range PendingWaitBuckets {
+			wait.buckets[bound] = 0
 		}
+		w[queue] = wait
+	}
 
-		ch <- prometheus.MustNewConstMetric(collector.CPUUtilization, prometheus.GaugeValue, CPUUtilization, hostname, queue)
+	wait.count++
+	wait.sum += seconds
 
-		//Iterate over Running Jobs
-		for _, j := range ql.JobList {
-			processJob(j, ch, collector, hostname, queue)
+	for _, bound := range PendingWaitBuckets {
+		if seconds <= bound {
+			wait.buckets[bound]++
 		}
 	}
+}
 
-	for _, j := range ji.PendingJobs.JobList {
-		//Process the hostname as the master
-		hostname, err := os.Hostname()
-		if err != nil {
-			hostname = "localhost"
+//snapshot serves the latest snapshot from the poller, refreshing it first if the poller is on demand
+func (collector *GridEngine) snapshot(poller *Poller) (*Snapshot, error) {
+	if poller.OnDemand() {
+		if err := poller.Refresh(); err != nil {
+			return nil, err
 		}
-		processJob(j, ch, collector, hostname, "pending")
 	}
 
+	snapshot := poller.Snapshot()
+	if snapshot == nil {
+		return nil, errors.New("no qstat snapshot has been taken yet")
+	}
+
+	return snapshot, nil
 }
 
-func processJob(j gogridengine.Job, ch chan<- prometheus.Metric, collector *GridEngine, hostname string, queue string) {
+//collectHealth reports on whether the exporter is able to talk to the grid
+func (collector *GridEngine) collectHealth(ch chan<- prometheus.Metric, poller *Poller) {
+	health := poller.Health()
+
+	up := 0.0
+	if health.LastError == nil && !health.LastSuccess.IsZero() {
+		up = 1
+	}
+
+	ch <- prometheus.MustNewConstMetric(collector.Up, prometheus.GaugeValue, up)
+
+	for _, stage := range Stages {
+		ch <- prometheus.MustNewConstMetric(collector.QstatErrors, prometheus.CounterValue, health.Errors[stage], stage)
+	}
+
+	ch <- prometheus.MustNewConstMetric(collector.QstatTimeouts, prometheus.CounterValue, health.Timeouts)
+	ch <- prometheus.MustNewConstMetric(collector.QstatRetries, prometheus.CounterValue, health.Retries)
+
+	lastSuccess := 0.0
+	if !health.LastSuccess.IsZero() {
+		lastSuccess = float64(health.LastSuccess.UnixNano()) / 1e9
+	}
+
+	ch <- prometheus.MustNewConstMetric(collector.LastSuccess, prometheus.GaugeValue, lastSuccess)
+}
+
+func processJob(j Job, ch chan<- prometheus.Metric, collector *GridEngine, hostname string, queue string) {
 	name := j.JobName
 	owner := j.JobOwner
 	number := strconv.FormatInt(j.JBJobNumber, 10)
 	taskID := strconv.Itoa(int(j.Tasks.TaskID))
 
-	ch <- prometheus.MustNewConstMetric(collector.JobState, prometheus.GaugeValue, float64(gogridengine.IsJobRunning(j)), hostname, queue, name, owner, number, taskID, j.State)
-	ch <- prometheus.MustNewConstMetric(collector.JobPriority, prometheus.GaugeValue, j.JATPriority, hostname, queue, name, owner, number, taskID, j.State)
-	ch <- prometheus.MustNewConstMetric(collector.JobSlots, prometheus.GaugeValue, float64(j.Slots), hostname, queue, name, owner, number, taskID, j.State)
-	ch <- prometheus.MustNewConstMetric(collector.JobErrors, prometheus.GaugeValue, float64(gogridengine.IsJobInErrorState(j)), hostname, queue, name, owner, number, taskID, j.State)
+	collector.emit(ch, collector.JobState, float64(gogridengine.IsJobRunning(j.Job)), hostname, queue, name, owner, number, taskID, j.State)
+	collector.emit(ch, collector.JobPriority, j.JATPriority, hostname, queue, name, owner, number, taskID, j.State)
+	collector.emit(ch, collector.JobSlots, float64(j.Slots), hostname, queue, name, owner, number, taskID, j.State)
+	collector.emit(ch, collector.JobErrors, float64(gogridengine.IsJobInErrorState(j.Job)), hostname, queue, name, owner, number, taskID, j.State)
+
+	if submitted, ok := j.Submitted(); ok {
+		ch <- prometheus.MustNewConstMetric(collector.JobSubmit, prometheus.GaugeValue, float64(submitted.Unix()), hostname, queue, name, owner, number, taskID, j.State)
+	}
+
+	if started, ok := j.Started(); ok {
+		ch <- prometheus.MustNewConstMetric(collector.JobStart, prometheus.GaugeValue, float64(started.Unix()), hostname, queue, name, owner, number, taskID, j.State)
+	}
 }
@@ -1,17 +1,25 @@
 package gridengine_prometheus
 
 import (
-	"math/rand"
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
 	"os"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
 )
 
-var entropy rand.Source
-var random *rand.Rand
+//testMaster is the hostname pending jobs are reported under in tests, so they don't depend on the machine running them
+const testMaster = "qmaster"
 
 func Test_newGridEngine(t *testing.T) {
 	tests := []struct {
@@ -22,17 +30,17 @@ func Test_newGridEngine(t *testing.T) {
 			name: "Normal operation Mode",
 			want: &GridEngine{
 				TotalSlots: prometheus.NewDesc(
-					"total_slots_count",
+					"sge_queue_slots",
 					"Total Number of slots available to the host",
 					[]string{"hostname", "queue"},
 					nil),
 				UsedSlots: prometheus.NewDesc(
-					"used_slots_count",
+					"sge_queue_slots_used",
 					"Number of used slots on host",
 					[]string{"hostname", "queue"},
 					nil),
 				ReservedSlots: prometheus.NewDesc(
-					"reserved_slots_count",
+					"sge_queue_slots_reserved",
 					"Number of reserved slots on host",
 					[]string{"hostname", "queue"},
 					nil),
@@ -42,7 +50,7 @@ func Test_newGridEngine(t *testing.T) {
 					[]string{"hostname", "queue"},
 					nil),
 				FreeMemory: prometheus.NewDesc(
-					"free_memory_bytes",
+					"sge_free_memory_bytes",
 					"Number of bytes in free memory",
 					[]string{"hostname", "queue"},
 					nil),
@@ -61,173 +69,426 @@ func Test_newGridEngine(t *testing.T) {
 					"Decimal representing total CPU utilization on host",
 					[]string{"hostname", "queue"},
 					nil),
+				ResourceValue: prometheus.NewDesc(
+					"sge_resource_value",
+					"Value of a numeric resource from the resource list of the queue instance, by resource and qstat resource type",
+					[]string{"hostname", "queue", "resource", "type"},
+					nil),
 				JobState: prometheus.NewDesc(
-					"job_state_value",
+					"sge_job_running",
 					"Indicates whether job is running (1) or not (0)",
 					[]string{"hostname", "queue", "name", "owner", "job_number", "task_id", "state"},
 					nil),
 				JobPriority: prometheus.NewDesc(
-					"job_priority_value",
+					"sge_job_priority",
 					"Qstat priority for given job",
 					[]string{"hostname", "queue", "name", "owner", "job_number", "task_id", "state"},
 					nil),
 				JobSlots: prometheus.NewDesc(
-					"job_slots_count",
+					"sge_job_slots_requested",
 					"Number of slots on the selected job",
 					[]string{"hostname", "queue", "name", "owner", "job_number", "task_id", "state"},
 					nil),
 				JobErrors: prometheus.NewDesc(
-					"job_errors",
+					"sge_job_error_state",
 					"Jobs that are reported in an errored or anomalous state",
 					[]string{"hostname", "queue", "name", "owner", "job_number", "task_id", "state"},
 					nil),
+				JobSubmit: prometheus.NewDesc(
+					"sge_job_submit_timestamp_seconds",
+					"Unix timestamp of when a pending job was submitted",
+					[]string{"hostname", "queue", "name", "owner", "job_number", "task_id", "state"},
+					nil),
+				JobStart: prometheus.NewDesc(
+					"sge_job_start_timestamp_seconds",
+					"Unix timestamp of when a running job started",
+					[]string{"hostname", "queue", "name", "owner", "job_number", "task_id", "state"},
+					nil),
+				PendingWait: prometheus.NewDesc(
+					"sge_pending_job_wait_seconds",
+					"How long currently pending jobs have been waiting since submission, by requested queue",
+					[]string{"queue"},
+					nil),
+				JobMemoryRequest: prometheus.NewDesc(
+					"sge_job_memory_request_bytes",
+					"Memory requested per slot by the job, by resource",
+					[]string{"hostname", "queue", "name", "owner", "job_number", "task_id", "state", "resource"},
+					nil),
+				JobRuntimeRequest: prometheus.NewDesc(
+					"sge_job_runtime_request_seconds",
+					"Run time limit (h_rt) requested by the job",
+					[]string{"hostname", "queue", "name", "owner", "job_number", "task_id", "state"},
+					nil),
+				JobPESlots: prometheus.NewDesc(
+					"sge_job_parallel_environment_slots",
+					"Number of slots granted to, or at least requested by, the job from its parallel environment",
+					[]string{"hostname", "queue", "name", "owner", "job_number", "task_id", "state", "pe"},
+					nil),
+				Jobs: prometheus.NewDesc(
+					"sge_jobs",
+					"Number of jobs by owner, queue and state",
+					[]string{"owner", "queue", "state"},
+					nil),
+				JobSlotsTotal: prometheus.NewDesc(
+					"sge_job_slots",
+					"Number of slots used or requested by jobs by owner, queue and state",
+					[]string{"owner", "queue", "state"},
+					nil),
+				JobsMemoryRequest: prometheus.NewDesc(
+					"sge_jobs_memory_request_bytes",
+					"Memory requested across every slot of jobs by owner, queue, state and resource",
+					[]string{"owner", "queue", "state", "resource"},
+					nil),
+				JobsRuntimeRequest: prometheus.NewDesc(
+					"sge_jobs_runtime_request_seconds",
+					"Sum of the run time limits (h_rt) requested by jobs by owner, queue and state",
+					[]string{"owner", "queue", "state"},
+					nil),
+				JobsPESlots: prometheus.NewDesc(
+					"sge_jobs_parallel_environment_slots",
+					"Slots granted to, or at least requested by, jobs from each parallel environment by owner, queue and state",
+					[]string{"owner", "queue", "state", "pe"},
+					nil),
+				QueueState: prometheus.NewDesc(
+					"sge_queue_instance_state",
+					"Whether the queue instance is currently in the given state (1) or not (0)",
+					[]string{"hostname", "queue", "state"},
+					nil),
+				SnapshotAge: prometheus.NewDesc(
+					"sge_snapshot_age_seconds",
+					"Number of seconds since the qstat snapshot being reported was taken",
+					nil,
+					nil),
+				RefreshDuration: prometheus.NewDesc(
+					"sge_snapshot_refresh_duration_seconds",
+					"Number of seconds it took to run and parse qstat for the snapshot being reported",
+					nil,
+					nil),
+				Up: prometheus.NewDesc(
+					"sge_up",
+					"Whether the most recent attempt to gather qstat details succeeded (1) or not (0)",
+					nil,
+					nil),
+				ScrapeDuration: prometheus.NewDesc(
+					"sge_scrape_duration_seconds",
+					"Number of seconds spent collecting grid engine metrics for this scrape",
+					nil,
+					nil),
+				QstatErrors: prometheus.NewDesc(
+					"sge_qstat_errors_total",
+					"Number of failures gathering qstat details by the stage at which they failed",
+					[]string{"stage"},
+					nil),
+				QstatTimeouts: prometheus.NewDesc(
+					"sge_qstat_timeouts_total",
+					"Number of times qstat was killed for running longer than the exec timeout",
+					nil,
+					nil),
+				QstatRetries: prometheus.NewDesc(
+					"sge_qstat_retries_total",
+					"Number of times qstat was run again after failing to run",
+					nil,
+					nil),
+				LastSuccess: prometheus.NewDesc(
+					"sge_last_success_timestamp_seconds",
+					"Unix timestamp of the most recent successful qstat snapshot",
+					nil,
+					nil),
+				MasterHostname: testMaster,
+				Poller:         NewPoller(0),
 			},
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if got := NewGridEngine(); !reflect.DeepEqual(got, tt.want) {
+			//The poller summarizes the jobs of each snapshot for the collector it was set up for
+			tt.want.Poller.Collector = tt.want
+
+			if got := NewGridEngine(WithMasterHostname(testMaster)); !reflect.DeepEqual(got, tt.want) {
 				t.Errorf("newGridEngine() = %v, want %v", got, tt.want)
 			}
 		})
 	}
 }
 
-func TestGridEngine_Describe(t *testing.T) {
-	description := NewGridEngine()
-	channel := make(chan *prometheus.Desc, 11)
-	type fields struct {
-		TotalSlots     *prometheus.Desc
-		UsedSlots      *prometheus.Desc
-		ReservedSlots  *prometheus.Desc
-		LoadAverage    *prometheus.Desc
-		FreeMemory     *prometheus.Desc
-		UsedMemory     *prometheus.Desc
-		TotalMemory    *prometheus.Desc
-		CPUUtilization *prometheus.Desc
-		JobState       *prometheus.Desc
-		JobPriority    *prometheus.Desc
-		JobSlots       *prometheus.Desc
-	}
-	type args struct {
-		ch chan<- *prometheus.Desc
+//update rewrites the golden files in testdata with the metrics currently collected, for when the output is meant to
+//change. Run go test -run TestGridEngine_Collect -update and review the difference
+var update = flag.Bool("update", false, "rewrite the golden metric files in testdata")
+
+//dynamicMetrics depend on when or in which time zone the tests are run, so are left out of the golden files.
+//TestGridEngine_CollectJobTimestamps covers the timestamps
+var dynamicMetrics = map[string]bool{
+	"sge_snapshot_age_seconds":              true,
+	"sge_snapshot_refresh_duration_seconds": true,
+	"sge_scrape_duration_seconds":           true,
+	"sge_last_success_timestamp_seconds":    true,
+	"sge_job_submit_timestamp_seconds":      true,
+	"sge_job_start_timestamp_seconds":       true,
+	"sge_pending_job_wait_seconds":          true,
+}
+
+//gatherStable collects the metrics that don't depend on when the tests are run, checking along the way that every
+//metric collected was described
+func gatherStable(t *testing.T, collector prometheus.Collector) ([]*dto.MetricFamily, []string) {
+	registry := prometheus.NewPedanticRegistry()
+	if err := registry.Register(collector); err != nil {
+		t.Fatal(err)
+	}
+
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
 	}
+
+	stable := make([]*dto.MetricFamily, 0, len(families))
+	names := make([]string, 0, len(families))
+
+	for _, family := range families {
+		if !dynamicMetrics[family.GetName()] {
+			stable = append(stable, family)
+			names = append(names, family.GetName())
+		}
+	}
+
+	return stable, names
+}
+
+func TestGridEngine_Collect(t *testing.T) {
 	tests := []struct {
 		name   string
-		fields fields
-		args   args
+		source func() Source
+		legacy bool
+		golden string
 	}{
 		{
-			name: "Normal operation",
-			fields: fields{
-				TotalSlots:     description.TotalSlots,
-				UsedSlots:      description.UsedSlots,
-				ReservedSlots:  description.ReservedSlots,
-				LoadAverage:    description.LoadAverage,
-				FreeMemory:     description.FreeMemory,
-				UsedMemory:     description.UsedMemory,
-				TotalMemory:    description.TotalMemory,
-				CPUUtilization: description.CPUUtilization,
-				JobState:       description.JobState,
-				JobPriority:    description.JobPriority,
-				JobSlots:       description.JobSlots,
+			name: "Fixture",
+			source: func() Source {
+				return FileSource{Path: "testdata/qstat.xml"}
+			},
+			golden: "testdata/qstat.prom",
+		},
+		{
+			name: "Fixture with legacy names",
+			source: func() Source {
+				return FileSource{Path: "testdata/qstat.xml"}
 			},
-			args: args{
-				ch: channel,
+			legacy: true,
+			golden: "testdata/qstat_legacy.prom",
+		},
+		{
+			name: "Simulated cluster",
+			source: func() Source {
+				return NewSimulator(SimulatorConfig{Seed: 4, Hosts: 2, Slots: 4, ArrivalRate: 6})
 			},
+			golden: "testdata/simulator.prom",
 		},
 	}
-
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			collector := &GridEngine{
-				TotalSlots:     tt.fields.TotalSlots,
-				UsedSlots:      tt.fields.UsedSlots,
-				ReservedSlots:  tt.fields.ReservedSlots,
-				LoadAverage:    tt.fields.LoadAverage,
-				FreeMemory:     tt.fields.FreeMemory,
-				UsedMemory:     tt.fields.UsedMemory,
-				TotalMemory:    tt.fields.TotalMemory,
-				CPUUtilization: tt.fields.CPUUtilization,
-				JobState:       tt.fields.JobState,
-				JobPriority:    tt.fields.JobPriority,
-				JobSlots:       tt.fields.JobSlots,
+			//Sources such as the simulator move on with every fetch, so each collection starts from a fresh one
+			collector := func() *GridEngine {
+				return NewGridEngine(WithSource(tt.source()), WithLegacyNames(tt.legacy), WithMasterHostname(testMaster))
+			}
+
+			families, names := gatherStable(t, collector())
+
+			if *update {
+				var text bytes.Buffer
+				for _, family := range families {
+					if _, err := expfmt.MetricFamilyToText(&text, family); err != nil {
+						t.Fatal(err)
+					}
+				}
+
+				if err := ioutil.WriteFile(tt.golden, text.Bytes(), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			golden, err := os.Open(tt.golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer golden.Close()
+
+			if err := testutil.CollectAndCompare(collector(), golden, names...); err != nil {
+				t.Errorf("Collected metrics differ from %s: %s", tt.golden, err)
 			}
-			collector.Describe(tt.args.ch)
 		})
 	}
 }
 
-func TestGridEngine_Collect(t *testing.T) {
-	description := NewGridEngine()
-	channel := make(chan prometheus.Metric, 100)
-	os.Setenv("GOGRIDENGINE_TEST", "true")
-	os.Setenv("GOGRIDENGINE_TEST_SOURCE", "https://gist.githubusercontent.com/shairozan/03f6f6123b11483bb17fd2c6ee95c338/raw/d65e6d603e7563b7307f9b045171ea7693c95f40/small_sge.xml")
-
-	//Prep the entropy components
-	entropy = rand.NewSource(time.Now().UnixNano())
-	random = rand.New(entropy)
-
-	type fields struct {
-		TotalSlots     *prometheus.Desc
-		UsedSlots      *prometheus.Desc
-		ReservedSlots  *prometheus.Desc
-		LoadAverage    *prometheus.Desc
-		FreeMemory     *prometheus.Desc
-		UsedMemory     *prometheus.Desc
-		TotalMemory    *prometheus.Desc
-		CPUUtilization *prometheus.Desc
-		JobState       *prometheus.Desc
-		JobPriority    *prometheus.Desc
-		JobSlots       *prometheus.Desc
-		JobErrors 	   *prometheus.Desc
-	}
-	type args struct {
-		ch chan<- prometheus.Metric
-	}
+func TestGridEngine_CollectHealth(t *testing.T) {
 	tests := []struct {
-		name   string
-		fields fields
-		args   args
+		name  string
+		fetch func() (string, error)
+		want  string
 	}{
 		{
-			name: "Normal Operation",
-			fields: fields{
-				TotalSlots:     description.TotalSlots,
-				UsedSlots:      description.UsedSlots,
-				ReservedSlots:  description.ReservedSlots,
-				LoadAverage:    description.LoadAverage,
-				FreeMemory:     description.FreeMemory,
-				UsedMemory:     description.UsedMemory,
-				TotalMemory:    description.TotalMemory,
-				CPUUtilization: description.CPUUtilization,
-				JobState:       description.JobState,
-				JobPriority:    description.JobPriority,
-				JobSlots:       description.JobSlots,
-				JobErrors:      description.JobErrors,
+			name:  "Healthy",
+			fetch: fixtureFetch(t, "testdata/qstat.xml"),
+			want: `
+# HELP sge_qstat_errors_total Number of failures gathering qstat details by the stage at which they failed
+# TYPE sge_qstat_errors_total counter
+sge_qstat_errors_total{stage="exec"} 0
+sge_qstat_errors_total{stage="parse"} 0
+sge_qstat_errors_total{stage="queue_name"} 0
+sge_qstat_errors_total{stage="resource"} 0
+# HELP sge_up Whether the most recent attempt to gather qstat details succeeded (1) or not (0)
+# TYPE sge_up gauge
+sge_up 1
+`,
+		},
+		{
+			name: "Qstat failure",
+			fetch: func() (string, error) {
+				return "", errors.New("qmaster unreachable")
 			},
-			args: args{
-				ch: channel,
+			want: `
+# HELP sge_qstat_errors_total Number of failures gathering qstat details by the stage at which they failed
+# TYPE sge_qstat_errors_total counter
+sge_qstat_errors_total{stage="exec"} 1
+sge_qstat_errors_total{stage="parse"} 0
+sge_qstat_errors_total{stage="queue_name"} 0
+sge_qstat_errors_total{stage="resource"} 0
+# HELP sge_up Whether the most recent attempt to gather qstat details succeeded (1) or not (0)
+# TYPE sge_up gauge
+sge_up 0
+`,
+		},
+		{
+			name: "Unparseable XML",
+			fetch: func() (string, error) {
+				return "<job_info><queue_info>", nil
 			},
+			want: `
+# HELP sge_qstat_errors_total Number of failures gathering qstat details by the stage at which they failed
+# TYPE sge_qstat_errors_total counter
+sge_qstat_errors_total{stage="exec"} 0
+sge_qstat_errors_total{stage="parse"} 1
+sge_qstat_errors_total{stage="queue_name"} 0
+sge_qstat_errors_total{stage="resource"} 0
+# HELP sge_up Whether the most recent attempt to gather qstat details succeeded (1) or not (0)
+# TYPE sge_up gauge
+sge_up 0
+`,
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			collector := &GridEngine{
-				TotalSlots:     tt.fields.TotalSlots,
-				UsedSlots:      tt.fields.UsedSlots,
-				ReservedSlots:  tt.fields.ReservedSlots,
-				LoadAverage:    tt.fields.LoadAverage,
-				FreeMemory:     tt.fields.FreeMemory,
-				UsedMemory:     tt.fields.UsedMemory,
-				TotalMemory:    tt.fields.TotalMemory,
-				CPUUtilization: tt.fields.CPUUtilization,
-				JobState:       tt.fields.JobState,
-				JobPriority:    tt.fields.JobPriority,
-				JobSlots:       tt.fields.JobSlots,
-				JobErrors: tt.fields.JobErrors,
+			collector := NewGridEngine(WithSource(SourceFunc(tt.fetch)))
+
+			if err := testutil.CollectAndCompare(collector, strings.NewReader(tt.want), "sge_up", "sge_qstat_errors_total"); err != nil {
+				t.Errorf("Unexpected health metrics: %s", err)
 			}
-			collector.Collect(tt.args.ch)
 		})
 	}
 }
+
+func TestGridEngine_CollectQueueState(t *testing.T) {
+	collector := NewGridEngine(WithSource(FileSource{Path: "testdata/qstat.xml"}))
+
+	var want strings.Builder
+	want.WriteString(`
+# HELP sge_queue_instance_state Whether the queue instance is currently in the given state (1) or not (0)
+# TYPE sge_queue_instance_state gauge
+`)
+	for _, host := range []string{"ip-172-16-2-102.us-west-2.compute.internal", "ip-172-16-2-251.us-west-2.compute.internal"} {
+		for _, state := range QueueStates {
+			value := 0
+			//Only the second host is disabled in the fixture
+			if host == "ip-172-16-2-251.us-west-2.compute.internal" && state.Name == "disabled" {
+				value = 1
+			}
+			fmt.Fprintf(&want, "sge_queue_instance_state{hostname=%q,queue=\"all.q\",state=%q} %d\n", host, state.Name, value)
+		}
+	}
+
+	if err := testutil.CollectAndCompare(collector, strings.NewReader(want.String()), "sge_queue_instance_state"); err != nil {
+		t.Errorf("Unexpected queue state metrics: %s", err)
+	}
+}
+
+func TestQueueStateFlags(t *testing.T) {
+	tests := []struct {
+		state string
+		want  []string
+	}{
+		{state: "", want: nil},
+		{state: "au", want: []string{"alarm", "unknown"}},
+		{state: "dE", want: []string{"disabled", "error"}},
+		{state: "sS", want: []string{"suspended", "subordinate_suspended"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.state, func(t *testing.T) {
+			got := QueueStateFlags(tt.state)
+
+			set := 0
+			for _, v := range got {
+				if v {
+					set++
+				}
+			}
+
+			if set != len(tt.want) {
+				t.Errorf("QueueStateFlags(%q) set %d states, want %d", tt.state, set, len(tt.want))
+			}
+
+			for _, name := range tt.want {
+				if !got[name] {
+					t.Errorf("QueueStateFlags(%q) did not set %s", tt.state, name)
+				}
+			}
+		})
+	}
+}
+
+func TestGridEngine_CollectJobTimestamps(t *testing.T) {
+	collector := NewGridEngine(WithSource(FileSource{Path: "testdata/qstat.xml"}), WithMasterHostname(testMaster))
+
+	unix := func(value string) int64 {
+		parsed, err := time.ParseInLocation("2006-01-02T15:04:05", value, time.Local)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return parsed.Unix()
+	}
+
+	want := fmt.Sprintf(`
+# HELP sge_job_start_timestamp_seconds Unix timestamp of when a running job started
+# TYPE sge_job_start_timestamp_seconds gauge
+sge_job_start_timestamp_seconds{hostname="ip-172-16-2-102.us-west-2.compute.internal",job_number="13",name="Run2",owner="jdoe",queue="all.q",state="r",task_id="0"} %[1]d
+sge_job_start_timestamp_seconds{hostname="ip-172-16-2-102.us-west-2.compute.internal",job_number="14",name="Run3",owner="asmith",queue="all.q",state="r",task_id="0"} %[2]d
+sge_job_start_timestamp_seconds{hostname="ip-172-16-2-102.us-west-2.compute.internal",job_number="17",name="Run6",owner="jdoe",queue="all.q",state="r",task_id="0"} %[3]d
+sge_job_start_timestamp_seconds{hostname="ip-172-16-2-251.us-west-2.compute.internal",job_number="17",name="Run6",owner="jdoe",queue="all.q",state="r",task_id="0"} %[3]d
+# HELP sge_job_submit_timestamp_seconds Unix timestamp of when a pending job was submitted
+# TYPE sge_job_submit_timestamp_seconds gauge
+sge_job_submit_timestamp_seconds{hostname=%[4]q,job_number="15",name="Run4",owner="jdoe",queue="pending",state="qw",task_id="0"} %[5]d
+sge_job_submit_timestamp_seconds{hostname=%[4]q,job_number="16",name="Run5",owner="asmith",queue="pending",state="Eqw",task_id="0"} %[6]d
+`, unix("2019-12-23T18:47:12"), unix("2019-12-23T18:48:02"), unix("2019-12-23T18:49:30"), testMaster, unix("2019-12-23T18:50:31"), unix("2019-12-23T18:51:10"))
+
+	if err := testutil.CollectAndCompare(collector, strings.NewReader(want), "sge_job_start_timestamp_seconds", "sge_job_submit_timestamp_seconds"); err != nil {
+		t.Errorf("Unexpected job timestamp metrics: %s", err)
+	}
+}
+
+func TestPendingWaits_Observe(t *testing.T) {
+	waits := newPendingWaits()
+	waits.observe("", 30)
+	waits.observe("", 4000)
+	waits.observe("long.q", 1000000)
+
+	any := waits["any"]
+	if any == nil || any.count != 2 || any.sum != 4030 {
+		t.Fatalf("Unexpected wait for jobs without a requested queue: %+v", any)
+	}
+
+	if any.buckets[60] != 1 || any.buckets[3600] != 1 || any.buckets[7200] != 2 || any.buckets[604800] != 2 {
+		t.Errorf("Unexpected bucket counts: %v", any.buckets)
+	}
+
+	long := waits["long.q"]
+	if long == nil || long.count != 1 || long.buckets[604800] != 0 {
+		t.Errorf("Unexpected wait for long.q: %+v", long)
+	}
+}
@@ -0,0 +1,17 @@
+//go:build !windows
+
+package gridengine_prometheus
+
+import (
+	"os"
+	"syscall"
+)
+
+//inode identifies the file on disk so we can tell after a restart whether it has been rotated
+func inode(fi os.FileInfo) uint64 {
+	if stat, ok := fi.Sys().(*syscall.Stat_t); ok {
+		return uint64(stat.Ino)
+	}
+
+	return 0
+}
@@ -0,0 +1,226 @@
+package gridengine_prometheus
+
+import (
+	"container/heap"
+
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+//pendingQueue is the queue pending jobs are reported under, as they haven't been scheduled onto one yet
+const pendingQueue = "pending"
+
+//jobEntry is a job along with where qstat reported it
+type jobEntry struct {
+	Job      Job
+	Hostname string
+	Queue    string
+	Requests JobRequests
+	//order is the position of the job in qstat's output
+	order int
+}
+
+//jobAggregateKey is the bounded set of labels jobs are summed by
+type jobAggregateKey struct {
+	Owner string
+	Queue string
+	State string
+}
+
+type jobAggregate struct {
+	Count float64
+	Slots float64
+	//Memory is the memory requested across every slot of the jobs, keyed by resource
+	Memory  map[string]float64
+	Runtime float64
+	//PESlots are the parallel environment slots of the jobs, keyed by parallel environment
+	PESlots map[string]float64
+}
+
+//jobSummary is what is reported from the jobs of a snapshot. It is built as they are decoded, so the only jobs held on
+//to are those with series of their own
+type jobSummary struct {
+	aggregates map[jobAggregateKey]*jobAggregate
+	series     jobSeries
+	waits      pendingWaits
+	//parallel are the tasks of the parallel jobs listed so far, as they are listed under each queue instance they run on
+	parallel map[jobTask]bool
+}
+
+//listed reports whether the job has already been listed under another queue instance. Only parallel jobs can run across
+//queue instances, so only their tasks are remembered
+func (summary *jobSummary) listed(j Job) bool {
+	if len(j.GrantedPE.Name) == 0 {
+		return false
+	}
+
+	task := j.task()
+	if summary.parallel[task] {
+		return true
+	}
+
+	summary.parallel[task] = true
+	return false
+}
+
+//summarize builds the job summary of the snapshot from each job as it is decoded
+func (collector *GridEngine) summarize(snapshot *Snapshot) QstatVisitor {
+	summary := &jobSummary{
+		aggregates: make(map[jobAggregateKey]*jobAggregate),
+		series: jobSeries{
+			max: collector.MaxJobSeries,
+		},
+		waits:    newPendingWaits(),
+		parallel: make(map[jobTask]bool),
+	}
+	snapshot.summary = summary
+
+	return QstatVisitor{
+		Queue: func(instance QueueInstance) {
+			//A malformed name is counted when the queue instance itself is reported
+			queue, hostname, _ := queueInstanceLabels(instance.Name, collector.ShortHostnames)
+
+			for _, j := range instance.Jobs {
+				collector.addJob(summary, jobEntry{
+					Job:      j,
+					Hostname: hostname,
+					Queue:    queue,
+				})
+			}
+		},
+		PendingJob: func(j Job) {
+			collector.addJob(summary, jobEntry{
+				Job:      j,
+				Hostname: collector.MasterHostname,
+				Queue:    pendingQueue,
+			})
+
+			if submitted, ok := j.Submitted(); ok {
+				summary.waits.observe(j.HardQueue, snapshot.Timestamp.Sub(submitted).Seconds())
+			}
+		},
+	}
+}
+
+//addJob adds the job to the aggregates, and keeps it for per job series if the collector is configured to report it. A
+//parallel job is counted, along with its run time and parallel environment slots, under the first queue instance it is
+//listed under. The slots and memory of every one of them are added
+func (collector *GridEngine) addJob(summary *jobSummary, entry jobEntry) {
+	requests, err := entry.Job.Requests()
+	if err != nil {
+		log.WithError(err).Error("There was an error extracting the resource requests of a job")
+		collector.Poller.RecordError(StageResource)
+	}
+	entry.Requests = requests
+
+	listed := summary.listed(entry.Job)
+
+	key := jobAggregateKey{
+		Owner: entry.Job.JobOwner,
+		Queue: entry.Queue,
+		State: entry.Job.State,
+	}
+
+	aggregate, ok := summary.aggregates[key]
+	if !ok {
+		aggregate = &jobAggregate{
+			Memory:  make(map[string]float64),
+			PESlots: make(map[string]float64),
+		}
+		summary.aggregates[key] = aggregate
+	}
+
+	aggregate.Slots += float64(entry.Job.Slots)
+
+	for resource, bytes := range requests.Memory {
+		aggregate.Memory[resource] += bytes * float64(entry.Job.Slots)
+	}
+
+	//The run time and parallel environment slots are for the whole job, wherever it is listed
+	if !listed {
+		aggregate.Count++
+		aggregate.Runtime += requests.Runtime
+
+		if len(requests.PE) > 0 {
+			aggregate.PESlots[requests.PE] += requests.PESlots
+		}
+	}
+
+	if !collector.DisableJobSeries {
+		summary.series.add(entry)
+	}
+}
+
+//collectJobs emits the per owner, queue and state aggregates for every job, and the per job series of the jobs kept
+func (collector *GridEngine) collectJobs(ch chan<- prometheus.Metric, summary *jobSummary) {
+	for key, aggregate := range summary.aggregates {
+		ch <- prometheus.MustNewConstMetric(collector.Jobs, prometheus.GaugeValue, aggregate.Count, key.Owner, key.Queue, key.State)
+		ch <- prometheus.MustNewConstMetric(collector.JobSlotsTotal, prometheus.GaugeValue, aggregate.Slots, key.Owner, key.Queue, key.State)
+		ch <- prometheus.MustNewConstMetric(collector.JobsRuntimeRequest, prometheus.GaugeValue, aggregate.Runtime, key.Owner, key.Queue, key.State)
+
+		for resource, bytes := range aggregate.Memory {
+			ch <- prometheus.MustNewConstMetric(collector.JobsMemoryRequest, prometheus.GaugeValue, bytes, key.Owner, key.Queue, key.State, resource)
+		}
+
+		for pe, slots := range aggregate.PESlots {
+			ch <- prometheus.MustNewConstMetric(collector.JobsPESlots, prometheus.GaugeValue, slots, key.Owner, key.Queue, key.State, pe)
+		}
+	}
+
+	for _, entry := range summary.series.jobs {
+		processJob(entry.Job, ch, collector, entry.Hostname, entry.Queue)
+		collector.collectJobRequests(ch, entry)
+	}
+}
+
+//jobSeries are the jobs kept for per job series. Every job is kept unless max limits them to that many of the highest
+//priority jobs, in which case jobs is a heap with the job to make way next at the top: the lowest priority job, and the
+//last in qstat's order between jobs of equal priority
+type jobSeries struct {
+	max  int
+	jobs []jobEntry
+	//next is the position in qstat's output of the next job added
+	next int
+}
+
+//add keeps the job if there is room for it, or if it has a higher priority than a job already kept
+func (s *jobSeries) add(entry jobEntry) {
+	entry.order = s.next
+	s.next++
+
+	switch {
+	case s.max <= 0:
+		s.jobs = append(s.jobs, entry)
+	case len(s.jobs) < s.max:
+		heap.Push(s, entry)
+	case entry.Job.JATPriority > s.jobs[0].Job.JATPriority:
+		s.jobs[0] = entry
+		heap.Fix(s, 0)
+	}
+}
+
+func (s *jobSeries) Len() int {
+	return len(s.jobs)
+}
+
+func (s *jobSeries) Less(i, j int) bool {
+	if s.jobs[i].Job.JATPriority != s.jobs[j].Job.JATPriority {
+		return s.jobs[i].Job.JATPriority < s.jobs[j].Job.JATPriority
+	}
+
+	return s.jobs[i].order > s.jobs[j].order
+}
+
+func (s *jobSeries) Swap(i, j int) {
+	s.jobs[i], s.jobs[j] = s.jobs[j], s.jobs[i]
+}
+
+func (s *jobSeries) Push(x interface{}) {
+	s.jobs = append(s.jobs, x.(jobEntry))
+}
+
+func (s *jobSeries) Pop() interface{} {
+	last := s.jobs[len(s.jobs)-1]
+	s.jobs = s.jobs[:len(s.jobs)-1]
+	return last
+}
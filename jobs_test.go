@@ -0,0 +1,108 @@
+package gridengine_prometheus
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestGridEngine_CollectJobAggregates(t *testing.T) {
+	collector := NewGridEngine(WithSource(FileSource{Path: "testdata/qstat.xml"}))
+
+	//The parallel job is listed under both queue instances it runs on, with its slots on each, but is a single job
+	want := `
+# HELP sge_job_slots Number of slots used or requested by jobs by owner, queue and state
+# TYPE sge_job_slots gauge
+sge_job_slots{owner="asmith",queue="all.q",state="r"} 1
+sge_job_slots{owner="asmith",queue="pending",state="Eqw"} 2
+sge_job_slots{owner="jdoe",queue="all.q",state="r"} 5
+sge_job_slots{owner="jdoe",queue="pending",state="qw"} 1
+# HELP sge_jobs Number of jobs by owner, queue and state
+# TYPE sge_jobs gauge
+sge_jobs{owner="asmith",queue="all.q",state="r"} 1
+sge_jobs{owner="asmith",queue="pending",state="Eqw"} 1
+sge_jobs{owner="jdoe",queue="all.q",state="r"} 2
+sge_jobs{owner="jdoe",queue="pending",state="qw"} 1
+`
+
+	if err := testutil.CollectAndCompare(collector, strings.NewReader(want), "sge_jobs", "sge_job_slots"); err != nil {
+		t.Errorf("Unexpected job aggregate metrics: %s", err)
+	}
+}
+
+func TestGridEngine_JobSeriesLimits(t *testing.T) {
+	tests := []struct {
+		name    string
+		disable bool
+		max     int
+		want    int
+	}{
+		{
+			name: "Unlimited",
+			want: 6,
+		},
+		{
+			name:    "Disabled",
+			disable: true,
+			want:    0,
+		},
+		{
+			name: "Capped",
+			max:  2,
+			want: 2,
+		},
+		{
+			name: "Cap above job count",
+			max:  10,
+			want: 6,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			collector := NewGridEngine(WithSource(FileSource{Path: "testdata/qstat.xml"}))
+			collector.DisableJobSeries = tt.disable
+			collector.MaxJobSeries = tt.max
+
+			if got := testutil.CollectAndCount(collector, "sge_job_running"); got != tt.want {
+				t.Errorf("Collect() emitted %d job series, want %d", got, tt.want)
+			}
+
+			//Aggregates are always reported
+			if got := testutil.CollectAndCount(collector, "sge_jobs"); got != 4 {
+				t.Errorf("Collect() emitted %d aggregate series, want 4", got)
+			}
+		})
+	}
+}
+
+func TestJobSeries_Add(t *testing.T) {
+	series := jobSeries{max: 2}
+
+	for _, j := range []struct {
+		queue    string
+		priority float64
+	}{
+		{queue: "pending", priority: 0.1},
+		{queue: "all.q", priority: 0.5},
+		{queue: "long.q", priority: 0.5},
+		{queue: "short.q", priority: 0.5},
+		{queue: "gpu.q", priority: 0.3},
+	} {
+		entry := jobEntry{Queue: j.queue}
+		entry.Job.JATPriority = j.priority
+		series.add(entry)
+	}
+
+	got := make([]string, 0)
+	for _, entry := range series.jobs {
+		got = append(got, entry.Queue)
+	}
+	sort.Strings(got)
+
+	if !reflect.DeepEqual(got, []string{"all.q", "long.q"}) {
+		t.Errorf("jobSeries.add() kept %v, want the two highest priority jobs earliest in qstat order", got)
+	}
+}
@@ -0,0 +1,117 @@
+package gridengine_prometheus
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+//DefaultNamespace is the prefix applied to every metric name unless another is configured
+const DefaultNamespace string = "sge"
+
+//Option configures a GridEngine collector at construction
+type Option func(*gridEngineOptions)
+
+type gridEngineOptions struct {
+	namespace    string
+	legacyNames  bool
+	source       Source
+	pollInterval time.Duration
+	retries      int
+	backoff      time.Duration
+	//masterHostname is empty unless set, in which case the hostname of the machine is used
+	masterHostname string
+}
+
+//WithNamespace sets the prefix applied to every metric name
+func WithNamespace(namespace string) Option {
+	return func(o *gridEngineOptions) {
+		o.namespace = namespace
+	}
+}
+
+//WithLegacyNames additionally reports the metrics whose names changed when namespacing was introduced under their
+//original names, so dashboards and alerts can be migrated. Will be removed in a future release
+func WithLegacyNames(enabled bool) Option {
+	return func(o *gridEngineOptions) {
+		o.legacyNames = enabled
+	}
+}
+
+//WithSource sets where the qstat XML comes from. Defaults to running qstat
+func WithSource(source Source) Option {
+	return func(o *gridEngineOptions) {
+		o.source = source
+	}
+}
+
+//WithPollInterval refreshes the qstat snapshot in the background on the interval once the poller is started, rather
+//than on every collection
+func WithPollInterval(interval time.Duration) Option {
+	return func(o *gridEngineOptions) {
+		o.pollInterval = interval
+	}
+}
+
+//WithRetries runs qstat up to retries more times when it fails to run, waiting backoff before the first retry and twice
+//as long before each one after
+func WithRetries(retries int, backoff time.Duration) Option {
+	return func(o *gridEngineOptions) {
+		o.retries = retries
+		o.backoff = backoff
+	}
+}
+
+//WithMasterHostname sets the hostname pending jobs are reported under. Defaults to the hostname of the machine running
+//the exporter
+func WithMasterHostname(hostname string) Option {
+	return func(o *gridEngineOptions) {
+		o.masterHostname = hostname
+	}
+}
+
+//legacyDescs builds the original, un-namespaced descriptions keyed by their current equivalent. Any the namespace
+//happens to reproduce exactly are left out so they aren't reported twice
+func legacyDescs(collector *GridEngine, name func(string) string, hostLabels []string, jobLabels []string) map[*prometheus.Desc]*prometheus.Desc {
+	legacy := []struct {
+		current *prometheus.Desc
+		name    string
+		metric  string
+		help    string
+		labels  []string
+	}{
+		{collector.TotalSlots, name("queue_slots"), "total_slots_count", "Total Number of slots available to the host", hostLabels},
+		{collector.UsedSlots, name("queue_slots_used"), "used_slots_count", "Number of used slots on host", hostLabels},
+		{collector.ReservedSlots, name("queue_slots_reserved"), "reserved_slots_count", "Number of reserved slots on host", hostLabels},
+		{collector.LoadAverage, name("load_average"), "sge_load_average", "Load average of this specific SGE host", hostLabels},
+		{collector.FreeMemory, name("free_memory_bytes"), "free_memory_bytes", "Number of bytes in free memory", hostLabels},
+		{collector.UsedMemory, name("used_memory_bytes"), "sge_used_memory_bytes", "Number of bytes in used memory", hostLabels},
+		{collector.TotalMemory, name("total_memory_bytes"), "sge_total_memory_bytes", "Number of bytes in total memory", hostLabels},
+		{collector.CPUUtilization, name("cpu_utilization_percent"), "sge_cpu_utilization_percent", "Decimal representing total CPU utilization on host", hostLabels},
+		{collector.JobState, name("job_running"), "job_state_value", "Indicates whether job is running (1) or not (0)", jobLabels},
+		{collector.JobPriority, name("job_priority"), "job_priority_value", "Qstat priority for given job", jobLabels},
+		{collector.JobSlots, name("job_slots_requested"), "job_slots_count", "Number of slots on the selected job", jobLabels},
+		{collector.JobErrors, name("job_error_state"), "job_errors", "Jobs that are reported in an errored or anomalous state", jobLabels},
+	}
+
+	descs := make(map[*prometheus.Desc]*prometheus.Desc, len(legacy))
+
+	for _, l := range legacy {
+		if l.name == l.metric {
+			continue
+		}
+
+		descs[l.current] = prometheus.NewDesc(l.metric, l.help, l.labels, nil)
+	}
+
+	return descs
+}
+
+//emit sends a gauge to the channel, along with its legacy named twin if legacy names are enabled
+func (collector *GridEngine) emit(ch chan<- prometheus.Metric, desc *prometheus.Desc, value float64, labels ...string) {
+	ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, value, labels...)
+
+	if legacy, ok := collector.legacy[desc]; ok {
+		ch <- prometheus.MustNewConstMetric(legacy, prometheus.GaugeValue, value, labels...)
+	}
+}
@@ -0,0 +1,107 @@
+package gridengine_prometheus
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestMetricNamesLint(t *testing.T) {
+	sge := NewGridEngine(WithSource(FileSource{Path: "testdata/qstat.xml"}))
+
+	hosts := NewHostCollector(DefaultNamespace)
+	hosts.fetch = fixtureFetch(t, "testdata/qhost.xml")
+
+	reasons := NewPendingReasonCollector(DefaultNamespace)
+	reasons.fetch = fixtureFetch(t, "testdata/qstat_j.xml")
+
+	quotas := NewQuotaCollector(DefaultNamespace)
+	quotas.fetch = fixtureFetch(t, "testdata/qquota.xml")
+
+	shares := NewShareTreeCollector(DefaultNamespace, "sge_share_mon")
+	shares.fetch = fixtureFetch(t, "testdata/sge_share_mon.txt")
+
+	collectors := map[string]prometheus.Collector{
+		"qstat":      sge,
+		"qhost":      hosts,
+		"accounting": NewAccountingCollector(DefaultNamespace, "", "", 0),
+		"qstat -j":   reasons,
+		"qquota":     quotas,
+		"share tree": shares,
+	}
+
+	for name, collector := range collectors {
+		t.Run(name, func(t *testing.T) {
+			problems, err := testutil.CollectAndLint(collector)
+			if err != nil {
+				t.Fatalf("Unable to lint metrics: %s", err)
+			}
+
+			for _, p := range problems {
+				t.Errorf("%s: %s", p.Metric, p.Text)
+			}
+		})
+	}
+}
+
+func TestGridEngine_LegacyNames(t *testing.T) {
+	tests := []struct {
+		name    string
+		options []Option
+		metrics []string
+		want    string
+	}{
+		{
+			name:    "Legacy names reported alongside current",
+			options: []Option{WithLegacyNames(true)},
+			metrics: []string{"sge_queue_slots", "total_slots_count"},
+			want: `
+# HELP sge_queue_slots Total Number of slots available to the host
+# TYPE sge_queue_slots gauge
+sge_queue_slots{hostname="ip-172-16-2-102.us-west-2.compute.internal",queue="all.q"} 4
+sge_queue_slots{hostname="ip-172-16-2-251.us-west-2.compute.internal",queue="all.q"} 4
+# HELP total_slots_count Total Number of slots available to the host
+# TYPE total_slots_count gauge
+total_slots_count{hostname="ip-172-16-2-102.us-west-2.compute.internal",queue="all.q"} 4
+total_slots_count{hostname="ip-172-16-2-251.us-west-2.compute.internal",queue="all.q"} 4
+`,
+		},
+		{
+			name:    "Legacy names off",
+			options: []Option{WithLegacyNames(false)},
+			metrics: []string{"sge_queue_slots", "total_slots_count"},
+			want: `
+# HELP sge_queue_slots Total Number of slots available to the host
+# TYPE sge_queue_slots gauge
+sge_queue_slots{hostname="ip-172-16-2-102.us-west-2.compute.internal",queue="all.q"} 4
+sge_queue_slots{hostname="ip-172-16-2-251.us-west-2.compute.internal",queue="all.q"} 4
+`,
+		},
+		{
+			name:    "Custom namespace",
+			options: []Option{WithNamespace("gridengine"), WithLegacyNames(true)},
+			metrics: []string{"gridengine_load_average", "sge_load_average"},
+			want: `
+# HELP gridengine_load_average Load average of this specific SGE host
+# TYPE gridengine_load_average gauge
+gridengine_load_average{hostname="ip-172-16-2-102.us-west-2.compute.internal",queue="all.q"} 0.45
+gridengine_load_average{hostname="ip-172-16-2-251.us-west-2.compute.internal",queue="all.q"} 0.01
+# HELP sge_load_average Load average of this specific SGE host
+# TYPE sge_load_average gauge
+sge_load_average{hostname="ip-172-16-2-102.us-west-2.compute.internal",queue="all.q"} 0.45
+sge_load_average{hostname="ip-172-16-2-251.us-west-2.compute.internal",queue="all.q"} 0.01
+`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			collector := NewGridEngine(append(tt.options, WithSource(FileSource{Path: "testdata/qstat.xml"}))...)
+
+			if err := testutil.CollectAndCompare(collector, strings.NewReader(tt.want), tt.metrics...); err != nil {
+				t.Errorf("Unexpected metrics: %s", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,346 @@
+package gridengine_prometheus
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/sync/singleflight"
+)
+
+//Stages at which gathering qstat metrics can fail. Used as the label for qstat error counts
+const (
+	StageExec      string = "exec"
+	StageParse     string = "parse"
+	StageResource  string = "resource"
+	StageQueueName string = "queue_name"
+)
+
+//Stages is every failure stage, used to make sure each is always reported even before it has failed
+var Stages = []string{StageExec, StageParse, StageResource, StageQueueName}
+
+//QstatError is a failure to gather qstat details along with the stage at which it failed
+type QstatError struct {
+	Stage string
+	Err   error
+}
+
+func (e *QstatError) Error() string {
+	return fmt.Sprintf("qstat %s failure: %s", e.Stage, e.Err)
+}
+
+func (e *QstatError) Unwrap() error {
+	return e.Err
+}
+
+//Snapshot is a single parsed qstat result along with details about when and how long it took to gather. qstat's output
+//isn't kept as a whole. Beyond the queue instances, only what is reported from the jobs is built as they are decoded
+type Snapshot struct {
+	//Queues are the queue instances, without the jobs running in them
+	Queues    []QueueInstance
+	Timestamp time.Time
+	Duration  time.Duration
+
+	//summary is what the collector reports from the jobs, and tracked is every task when job events are being counted
+	summary *jobSummary
+	tracked map[string]trackedJob
+}
+
+//snapshotBuilder returns the visitor that builds what is reported from a snapshot as qstat's output is decoded
+type snapshotBuilder func(snapshot *Snapshot) QstatVisitor
+
+//Health summarizes how qstat runs have been going
+type Health struct {
+	//LastError is the error from the most recent refresh, or nil if it succeeded
+	LastError error
+	//LastSuccess is when the most recent successful snapshot was taken
+	LastSuccess time.Time
+	//Errors counts failures by stage
+	Errors map[string]float64
+	//Timeouts counts the qstat runs killed for taking too long
+	Timeouts float64
+	//Retries counts the qstat runs that were tried again after failing
+	Retries float64
+}
+
+//Poller runs qstat in the background on a fixed interval and holds on to the most recent snapshot so that
+//scrapes never have to wait on the qmaster. A poller with an interval of 0 is never started and is instead
+//refreshed on demand for every collection. Refreshes that overlap share a single run of qstat
+type Poller struct {
+	Interval time.Duration
+	//Collector, if set, builds what it reports from the jobs of every snapshot as they are decoded
+	Collector *GridEngine
+	//Recorder, if set, saves every qstat payload fetched
+	Recorder *Recorder
+	//Events, if set, is handed every successful snapshot to count the jobs starting, finishing and erroring
+	Events *JobEventCollector
+	//Retries is how many more times qstat is run when it fails to run, waiting Backoff before the first retry and
+	//twice as long before each one after
+	Retries int
+	Backoff time.Duration
+
+	//Source supplies the raw qstat XML
+	Source Source
+
+	mutex    sync.RWMutex
+	latest   *Snapshot
+	lastErr  error
+	errors   map[string]float64
+	timeouts float64
+	retries  float64
+
+	//refreshes holds the refresh in flight, if there is one
+	refreshes singleflight.Group
+}
+
+//refreshKey is the key every refresh shares in flight under
+const refreshKey = "refresh"
+
+//NewPoller returns a poller that will refresh its snapshot from qstat every interval once started
+func NewPoller(interval time.Duration) *Poller {
+	return &Poller{
+		Interval: interval,
+		Source:   QstatSource{},
+		errors:   make(map[string]float64),
+	}
+}
+
+//OnDemand indicates the poller is not refreshed in the background and should be refreshed before use
+func (p *Poller) OnDemand() bool {
+	return p.Interval <= 0
+}
+
+//Start performs an initial refresh and then continues refreshing on the configured interval until the context is done.
+//On demand pollers are left alone
+func (p *Poller) Start(ctx context.Context) {
+	if p.OnDemand() {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(p.Interval)
+		defer ticker.Stop()
+
+		for {
+			if err := p.RefreshContext(ctx); err != nil {
+				log.WithError(err).Error("Background qstat refresh failed. Continuing to serve the previous snapshot")
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+//Refresh runs qstat and replaces the held snapshot if it was successful. If a refresh is already in flight, its result
+//is waited on and shared rather than running qstat again
+func (p *Poller) Refresh() error {
+	return p.RefreshContext(context.Background())
+}
+
+//RefreshContext is Refresh, giving up on retrying qstat once the context is done. A refresh already in flight carries
+//on with the context it was started with
+func (p *Poller) RefreshContext(ctx context.Context) error {
+	_, err, _ := p.refreshes.Do(refreshKey, func() (interface{}, error) {
+		return nil, p.refresh(ctx)
+	})
+
+	return err
+}
+
+func (p *Poller) refresh(ctx context.Context) error {
+	source := p.Source
+	if source == nil {
+		source = QstatSource{}
+	}
+
+	if p.Recorder != nil {
+		source = p.Recorder.Wrap(source)
+	}
+
+	builders := make([]snapshotBuilder, 0, 2)
+	if p.Collector != nil {
+		builders = append(builders, p.Collector.summarize)
+	}
+	if p.Events != nil {
+		builders = append(builders, trackJobs)
+	}
+
+	var snapshot *Snapshot
+	var err error
+
+	backoff := p.Backoff
+
+	for attempt := 0; ; attempt++ {
+		snapshot, err = takeSnapshot(source, builders...)
+
+		if errors.Is(err, ErrCommandTimeout) {
+			p.mutex.Lock()
+			p.timeouts++
+			p.mutex.Unlock()
+		}
+
+		//Only failing to run qstat is worth trying again. The same output would fail to parse the same way
+		var qe *QstatError
+		if err == nil || attempt >= p.Retries || !errors.As(err, &qe) || qe.Stage != StageExec {
+			break
+		}
+
+		log.WithError(err).Warnf("Unable to run qstat. Trying again in %s", backoff)
+
+		if !wait(ctx, backoff) {
+			break
+		}
+
+		p.mutex.Lock()
+		p.retries++
+		p.mutex.Unlock()
+
+		backoff *= 2
+	}
+
+	//Failing to persist the events is logged rather than failing the refresh, as the snapshot itself is fine
+	if err == nil && p.Events != nil {
+		if err := p.Events.Observe(snapshot); err != nil {
+			log.WithError(err).Errorf("Unable to save the job event state to %s", p.Events.StatePath)
+		}
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.lastErr = err
+
+	if err != nil {
+		stage := StageExec
+		if qe, ok := err.(*QstatError); ok {
+			stage = qe.Stage
+		}
+		p.errors[stage]++
+		return err
+	}
+
+	p.latest = snapshot
+
+	return nil
+}
+
+//wait waits for the duration, returning false if the context is done first
+func wait(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+//Snapshot returns the most recent successful snapshot, or nil if one has not been taken yet
+func (p *Poller) Snapshot() *Snapshot {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	return p.latest
+}
+
+//RecordError counts a failure at the provided stage that happened outside of the refresh itself, such as
+//extracting resource values from an otherwise valid snapshot
+func (p *Poller) RecordError(stage string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.errors[stage]++
+}
+
+//Health returns a copy of the poller's current health details
+func (p *Poller) Health() Health {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+
+	h := Health{
+		LastError: p.lastErr,
+		Errors:    make(map[string]float64, len(p.errors)),
+		Timeouts:  p.timeouts,
+		Retries:   p.retries,
+	}
+
+	if p.latest != nil {
+		h.LastSuccess = p.latest.Timestamp
+	}
+
+	for stage, count := range p.errors {
+		h.Errors[stage] = count
+	}
+
+	return h
+}
+
+//takeSnapshot reads the qstat XML from the source and decodes it, recording how long the whole operation took. Sources
+//that can stream are decoded as they are read, so the raw XML is never held in memory. Each builder is handed every
+//queue instance and pending job as it is decoded, and the jobs aren't kept once they have been
+func takeSnapshot(source Source, builders ...snapshotBuilder) (*Snapshot, error) {
+	start := time.Now()
+
+	stream, err := openStream(source)
+	if err != nil {
+		return nil, &QstatError{
+			Stage: StageExec,
+			Err:   fmt.Errorf("there was an error processing the XML output: %w", err),
+		}
+	}
+
+	snapshot := &Snapshot{
+		Timestamp: start,
+	}
+
+	visitors := make([]QstatVisitor, 0, len(builders))
+	for _, build := range builders {
+		visitors = append(visitors, build(snapshot))
+	}
+
+	err = DecodeQstat(stream, QstatVisitor{
+		Queue: func(instance QueueInstance) {
+			for _, v := range visitors {
+				if v.Queue != nil {
+					v.Queue(instance)
+				}
+			}
+
+			instance.Jobs = nil
+			snapshot.Queues = append(snapshot.Queues, instance)
+		},
+		PendingJob: func(job Job) {
+			for _, v := range visitors {
+				if v.PendingJob != nil {
+					v.PendingJob(job)
+				}
+			}
+		},
+	})
+
+	//qstat failing part way through is reported over the XML it left unfinished
+	if closeErr := stream.Close(); closeErr != nil {
+		return nil, &QstatError{
+			Stage: StageExec,
+			Err:   fmt.Errorf("there was an error processing the XML output: %w", closeErr),
+		}
+	}
+
+	if err != nil {
+		return nil, &QstatError{
+			Stage: StageParse,
+			Err:   fmt.Errorf("unable to decode the XML cleanly into an object: %w", err),
+		}
+	}
+
+	snapshot.Duration = time.Since(start)
+
+	return snapshot, nil
+}
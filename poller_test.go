@@ -0,0 +1,309 @@
+package gridengine_prometheus
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/sync/singleflight"
+)
+
+func fixtureFetch(t *testing.T, path string) func() (string, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Unable to read fixture %s: %s", path, err)
+	}
+
+	return func() (string, error) {
+		return string(content), nil
+	}
+}
+
+func TestPoller_Refresh(t *testing.T) {
+	tests := []struct {
+		name       string
+		fetch      func() (string, error)
+		wantErr    bool
+		wantQueues int
+	}{
+		{
+			name:       "Valid XML",
+			fetch:      fixtureFetch(t, "testdata/qstat.xml"),
+			wantErr:    false,
+			wantQueues: 2,
+		},
+		{
+			name: "Qstat failure",
+			fetch: func() (string, error) {
+				return "", errors.New("qmaster unreachable")
+			},
+			wantErr: true,
+		},
+		{
+			name: "Invalid XML",
+			fetch: func() (string, error) {
+				return "<job_info><queue_info>", nil
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := NewPoller(time.Minute)
+			p.Source = SourceFunc(tt.fetch)
+
+			if err := p.Refresh(); (err != nil) != tt.wantErr {
+				t.Errorf("Refresh() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			snapshot := p.Snapshot()
+			if tt.wantErr {
+				if snapshot != nil {
+					t.Errorf("Snapshot() = %v, want nil after a failed refresh", snapshot)
+				}
+				return
+			}
+
+			if got := len(snapshot.Queues); got != tt.wantQueues {
+				t.Errorf("Snapshot() has %d queues, want %d", got, tt.wantQueues)
+			}
+		})
+	}
+}
+
+func TestPoller_KeepsLastSnapshotOnFailure(t *testing.T) {
+	p := NewPoller(time.Minute)
+	p.Source = FileSource{Path: "testdata/qstat.xml"}
+
+	if err := p.Refresh(); err != nil {
+		t.Fatalf("Refresh() unexpected error = %v", err)
+	}
+
+	previous := p.Snapshot()
+
+	p.Source = SourceFunc(func() (string, error) {
+		return "", errors.New("qmaster unreachable")
+	})
+
+	if err := p.Refresh(); err == nil {
+		t.Errorf("Refresh() expected an error")
+	}
+
+	if p.Snapshot() != previous {
+		t.Errorf("Snapshot() was replaced after a failed refresh")
+	}
+}
+
+func TestPoller_Start(t *testing.T) {
+	calls := make(chan struct{}, 10)
+	fetch := fixtureFetch(t, "testdata/qstat.xml")
+
+	p := NewPoller(10 * time.Millisecond)
+	p.Source = SourceFunc(func() (string, error) {
+		calls <- struct{}{}
+		return fetch()
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	p.Start(ctx)
+
+	//Initial refresh plus at least one tick
+	for i := 0; i < 2; i++ {
+		select {
+		case <-calls:
+		case <-time.After(time.Second):
+			t.Fatalf("Poller did not refresh in time")
+		}
+	}
+
+	if p.Snapshot() == nil {
+		t.Errorf("Snapshot() = nil after the poller refreshed")
+	}
+}
+
+func TestPoller_Retries(t *testing.T) {
+	fetch := fixtureFetch(t, "testdata/qstat.xml")
+	timeout := fmt.Errorf("%w: qstat ran for longer than 30s", ErrCommandTimeout)
+
+	tests := []struct {
+		name         string
+		retries      int
+		failures     []error
+		payload      string
+		wantErr      bool
+		cancelled    bool
+		wantCalls    int
+		wantRetries  float64
+		wantTimeouts float64
+	}{
+		{
+			name:         "Succeeds on a retry",
+			retries:      2,
+			failures:     []error{errors.New("qmaster unreachable"), timeout},
+			wantCalls:    3,
+			wantRetries:  2,
+			wantTimeouts: 1,
+		},
+		{
+			name:         "Gives up after the retries",
+			retries:      2,
+			failures:     []error{timeout, timeout, timeout, timeout},
+			wantErr:      true,
+			wantCalls:    3,
+			wantRetries:  2,
+			wantTimeouts: 3,
+		},
+		{
+			name:      "Retries disabled",
+			failures:  []error{errors.New("qmaster unreachable")},
+			wantErr:   true,
+			wantCalls: 1,
+		},
+		{
+			name:      "Stops retrying once cancelled",
+			retries:   2,
+			failures:  []error{errors.New("qmaster unreachable")},
+			cancelled: true,
+			wantErr:   true,
+			wantCalls: 1,
+		},
+		{
+			name:      "Parse failures aren't retried",
+			retries:   2,
+			payload:   "<job_info><queue_info>",
+			wantErr:   true,
+			wantCalls: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+
+			p := NewPoller(time.Minute)
+			p.Retries = tt.retries
+			p.Backoff = time.Millisecond
+			p.Source = SourceFunc(func() (string, error) {
+				calls++
+				if calls <= len(tt.failures) {
+					return "", tt.failures[calls-1]
+				}
+				if len(tt.payload) > 0 {
+					return tt.payload, nil
+				}
+				return fetch()
+			})
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			if tt.cancelled {
+				cancel()
+			}
+
+			if err := p.RefreshContext(ctx); (err != nil) != tt.wantErr {
+				t.Errorf("RefreshContext() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if calls != tt.wantCalls {
+				t.Errorf("Refresh() ran qstat %d times, want %d", calls, tt.wantCalls)
+			}
+
+			health := p.Health()
+			if health.Retries != tt.wantRetries {
+				t.Errorf("Health().Retries = %v, want %v", health.Retries, tt.wantRetries)
+			}
+			if health.Timeouts != tt.wantTimeouts {
+				t.Errorf("Health().Timeouts = %v, want %v", health.Timeouts, tt.wantTimeouts)
+			}
+		})
+	}
+}
+
+func TestPoller_RefreshSharesInFlight(t *testing.T) {
+	fetch := fixtureFetch(t, "testdata/qstat.xml")
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	var calls int32
+
+	p := NewPoller(0)
+	p.Source = SourceFunc(func() (string, error) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			close(started)
+		}
+		<-release
+		return fetch()
+	})
+
+	done := make(chan error, 1)
+	go func() {
+		done <- p.Refresh()
+	}()
+	<-started
+
+	//Every scrape arriving while qstat is still running waits on that run rather than starting another. DoChan has
+	//joined the run in flight by the time it returns, so qstat is only released once every waiter is known to be waiting
+	waiters := make([]<-chan singleflight.Result, 0, 4)
+	for i := 0; i < 4; i++ {
+		waiters = append(waiters, p.refreshes.DoChan(refreshKey, func() (interface{}, error) {
+			return nil, p.refresh(context.Background())
+		}))
+	}
+
+	close(release)
+
+	if err := <-done; err != nil {
+		t.Errorf("Refresh() unexpected error = %v", err)
+	}
+
+	for _, waiter := range waiters {
+		result := <-waiter
+		if result.Err != nil {
+			t.Errorf("Refresh() unexpected error = %v", result.Err)
+		}
+		if !result.Shared {
+			t.Error("Expected the refresh in flight to be shared")
+		}
+	}
+
+	if got := atomic.LoadInt32(&calls); got != 1 {
+		t.Errorf("Overlapping refreshes ran qstat %d times, want 1", got)
+	}
+}
+
+func TestGridEngine_CollectFromPoller(t *testing.T) {
+	calls := 0
+	fetch := fixtureFetch(t, "testdata/qstat.xml")
+
+	collector := NewGridEngine(WithPollInterval(time.Minute), WithSource(SourceFunc(func() (string, error) {
+		calls++
+		return fetch()
+	})))
+	p := collector.Poller
+
+	channel := make(chan prometheus.Metric, 1000)
+
+	//Without a snapshot only the health details should be emitted
+	collector.Collect(channel)
+	if len(channel) != 2+len(Stages)+2+1 {
+		t.Errorf("Collect() emitted %d metrics without a snapshot", len(channel))
+	}
+
+	if err := p.Refresh(); err != nil {
+		t.Fatalf("Refresh() unexpected error = %v", err)
+	}
+
+	collector.Collect(channel)
+	collector.Collect(channel)
+
+	if calls != 1 {
+		t.Errorf("qstat was run %d times, want 1", calls)
+	}
+}
@@ -0,0 +1,17 @@
+//go:build !windows
+
+package gridengine_prometheus
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+//killProcessGroup starts the command in a process group of its own and kills the whole group when its context is done,
+//rather than just the command itself
+func killProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+}
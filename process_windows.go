@@ -0,0 +1,8 @@
+//go:build windows
+
+package gridengine_prometheus
+
+import "os/exec"
+
+//killProcessGroup leaves the command to be killed by itself, as there are no process groups to kill on windows
+func killProcessGroup(cmd *exec.Cmd) {}
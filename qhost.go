@@ -0,0 +1,245 @@
+package gridengine_prometheus
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net"
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+//QhostInfo is the root of the XML document produced by qhost -xml
+type QhostInfo struct {
+	XMLName xml.Name `xml:"qhost"`
+	Hosts   []Host   `xml:"host"`
+}
+
+//Host is a single execution host as reported by qhost
+type Host struct {
+	Name   string      `xml:"name,attr"`
+	Values []HostValue `xml:"hostvalue"`
+}
+
+//HostValue is a single named value for a host. Unavailable values are reported by qhost as "-"
+type HostValue struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:",chardata"`
+}
+
+//Value looks up the named value for the host, indicating whether it was present and available
+func (h Host) Value(name string) (string, bool) {
+	for _, v := range h.Values {
+		if v.Name == name {
+			return v.Value, v.Value != "-" && len(v.Value) > 0
+		}
+	}
+
+	return "", false
+}
+
+//HostCollector reports on execution hosts using qhost, independently of the queue instances they belong to
+type HostCollector struct {
+	Up          *prometheus.Desc
+	Info        *prometheus.Desc
+	CPUs        *prometheus.Desc
+	Sockets     *prometheus.Desc
+	Cores       *prometheus.Desc
+	Threads     *prometheus.Desc
+	LoadAverage *prometheus.Desc
+	MemoryTotal *prometheus.Desc
+	MemoryUsed  *prometheus.Desc
+	SwapTotal   *prometheus.Desc
+	SwapUsed    *prometheus.Desc
+
+	//ShortHostnames strips the domain from hostnames, matching GridEngine.ShortHostnames
+	ShortHostnames bool
+
+	//Runner runs qhost against the grid engine cell. nil runs it in the environment of the exporter
+	Runner *CommandRunner
+
+	//fetch is how we get the raw qhost XML. Swappable for testing
+	fetch func() (string, error)
+}
+
+//NewHostCollector returns a collector that runs qhost on every collection, with metric names prefixed by namespace
+func NewHostCollector(namespace string) *HostCollector {
+	hostLabels := []string{
+		"hostname",
+	}
+
+	name := func(metric string) string {
+		return prometheus.BuildFQName(namespace, "", metric)
+	}
+
+	collector := &HostCollector{
+		Up: prometheus.NewDesc(
+			name("qhost_up"),
+			"Whether qhost was able to run and be parsed (1) or not (0)",
+			nil,
+			nil),
+		Info: prometheus.NewDesc(
+			name("host_info"),
+			"Static details about an execution host. Value is always 1",
+			[]string{"hostname", "arch"},
+			nil),
+		CPUs: prometheus.NewDesc(
+			name("host_cpus"),
+			"Number of processors on the host",
+			hostLabels,
+			nil),
+		Sockets: prometheus.NewDesc(
+			name("host_sockets"),
+			"Number of sockets on the host",
+			hostLabels,
+			nil),
+		Cores: prometheus.NewDesc(
+			name("host_cores"),
+			"Number of cores on the host",
+			hostLabels,
+			nil),
+		Threads: prometheus.NewDesc(
+			name("host_threads"),
+			"Number of hardware threads on the host",
+			hostLabels,
+			nil),
+		LoadAverage: prometheus.NewDesc(
+			name("host_load_average"),
+			"Load average of the host as reported by qhost",
+			hostLabels,
+			nil),
+		MemoryTotal: prometheus.NewDesc(
+			name("host_memory_total_bytes"),
+			"Number of bytes of memory on the host",
+			hostLabels,
+			nil),
+		MemoryUsed: prometheus.NewDesc(
+			name("host_memory_used_bytes"),
+			"Number of bytes of memory in use on the host",
+			hostLabels,
+			nil),
+		SwapTotal: prometheus.NewDesc(
+			name("host_swap_total_bytes"),
+			"Number of bytes of swap on the host",
+			hostLabels,
+			nil),
+		SwapUsed: prometheus.NewDesc(
+			name("host_swap_used_bytes"),
+			"Number of bytes of swap in use on the host",
+			hostLabels,
+			nil),
+	}
+	collector.fetch = collector.qhostOutput
+
+	return collector
+}
+
+//Describe provides prometheus with descriptions and details (not values) of each metric
+func (collector *HostCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- collector.Up
+	ch <- collector.Info
+	ch <- collector.CPUs
+	ch <- collector.Sockets
+	ch <- collector.Cores
+	ch <- collector.Threads
+	ch <- collector.LoadAverage
+	ch <- collector.MemoryTotal
+	ch <- collector.MemoryUsed
+	ch <- collector.SwapTotal
+	ch <- collector.SwapUsed
+}
+
+//Collect runs qhost and feeds a set of metrics for each host into the channel
+func (collector *HostCollector) Collect(ch chan<- prometheus.Metric) {
+	qi, err := collector.qhost()
+	if err != nil {
+		log.WithError(err).Error("Unable to gather host details from qhost")
+		ch <- prometheus.MustNewConstMetric(collector.Up, prometheus.GaugeValue, 0)
+		return
+	}
+
+	ch <- prometheus.MustNewConstMetric(collector.Up, prometheus.GaugeValue, 1)
+
+	for _, h := range qi.Hosts {
+		//The global host is a placeholder for cluster wide values and never has any host details
+		if h.Name == "global" {
+			continue
+		}
+
+		if collector.ShortHostnames && net.ParseIP(h.Name) == nil {
+			h.Name = ShortHostname(h.Name)
+		}
+
+		if arch, ok := h.Value("arch_string"); ok {
+			ch <- prometheus.MustNewConstMetric(collector.Info, prometheus.GaugeValue, 1, h.Name, arch)
+		}
+
+		collector.collectNumber(ch, h, "num_proc", collector.CPUs)
+		collector.collectNumber(ch, h, "m_socket", collector.Sockets)
+		collector.collectNumber(ch, h, "m_core", collector.Cores)
+		collector.collectNumber(ch, h, "m_thread", collector.Threads)
+		collector.collectNumber(ch, h, "load_avg", collector.LoadAverage)
+		collector.collectSize(ch, h, "mem_total", collector.MemoryTotal)
+		collector.collectSize(ch, h, "mem_used", collector.MemoryUsed)
+		collector.collectSize(ch, h, "swap_total", collector.SwapTotal)
+		collector.collectSize(ch, h, "swap_used", collector.SwapUsed)
+	}
+}
+
+func (collector *HostCollector) qhost() (QhostInfo, error) {
+	qi := QhostInfo{}
+
+	fetch := collector.fetch
+	if fetch == nil {
+		fetch = collector.qhostOutput
+	}
+
+	x, err := fetch()
+	if err != nil {
+		return qi, fmt.Errorf("there was an error running qhost: %w", err)
+	}
+
+	err = xml.Unmarshal([]byte(x), &qi)
+	if err != nil {
+		return qi, fmt.Errorf("unable to marshal the qhost XML cleanly into an object: %w", err)
+	}
+
+	return qi, nil
+}
+
+//collectNumber emits the named host value as a plain number. Unavailable values are skipped rather than reported as 0
+func (collector *HostCollector) collectNumber(ch chan<- prometheus.Metric, h Host, name string, desc *prometheus.Desc) {
+	value, ok := h.Value(name)
+	if !ok {
+		return
+	}
+
+	parsed, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		log.WithError(err).Errorf("There was an error extracting %s for host %s", name, h.Name)
+		return
+	}
+
+	ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, parsed, h.Name)
+}
+
+//collectSize emits the named host value converted from an SGE memory size into bytes
+func (collector *HostCollector) collectSize(ch chan<- prometheus.Metric, h Host, name string, desc *prometheus.Desc) {
+	value, ok := h.Value(name)
+	if !ok {
+		return
+	}
+
+	parsed, err := ParseSize(value)
+	if err != nil {
+		log.WithError(err).Errorf("There was an error extracting %s for host %s", name, h.Name)
+		return
+	}
+
+	ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, parsed, h.Name)
+}
+
+func (collector *HostCollector) qhostOutput() (string, error) {
+	return collector.Runner.Output("qhost", "-xml")
+}
@@ -0,0 +1,109 @@
+package gridengine_prometheus
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestHostCollector_Collect(t *testing.T) {
+	tests := []struct {
+		name    string
+		fetch   func() (string, error)
+		metrics []string
+		want    string
+	}{
+		{
+			name:    "Host details",
+			fetch:   fixtureFetch(t, "testdata/qhost.xml"),
+			metrics: []string{"sge_qhost_up", "sge_host_info", "sge_host_cpus", "sge_host_load_average"},
+			want: `
+# HELP sge_host_cpus Number of processors on the host
+# TYPE sge_host_cpus gauge
+sge_host_cpus{hostname="ip-172-16-2-102.us-west-2.compute.internal"} 4
+sge_host_cpus{hostname="ip-172-16-2-251.us-west-2.compute.internal"} 8
+sge_host_cpus{hostname="ip-172-16-2-37.us-west-2.compute.internal"} 4
+# HELP sge_host_info Static details about an execution host. Value is always 1
+# TYPE sge_host_info gauge
+sge_host_info{arch="lx-amd64",hostname="ip-172-16-2-102.us-west-2.compute.internal"} 1
+sge_host_info{arch="lx-amd64",hostname="ip-172-16-2-251.us-west-2.compute.internal"} 1
+sge_host_info{arch="lx-amd64",hostname="ip-172-16-2-37.us-west-2.compute.internal"} 1
+# HELP sge_host_load_average Load average of the host as reported by qhost
+# TYPE sge_host_load_average gauge
+sge_host_load_average{hostname="ip-172-16-2-102.us-west-2.compute.internal"} 0.45
+sge_host_load_average{hostname="ip-172-16-2-251.us-west-2.compute.internal"} 1.5
+# HELP sge_qhost_up Whether qhost was able to run and be parsed (1) or not (0)
+# TYPE sge_qhost_up gauge
+sge_qhost_up 1
+`,
+		},
+		{
+			name:    "Memory is reported in bytes",
+			fetch:   fixtureFetch(t, "testdata/qhost.xml"),
+			metrics: []string{"sge_host_memory_total_bytes", "sge_host_swap_used_bytes"},
+			want: `
+# HELP sge_host_memory_total_bytes Number of bytes of memory on the host
+# TYPE sge_host_memory_total_bytes gauge
+sge_host_memory_total_bytes{hostname="ip-172-16-2-102.us-west-2.compute.internal"} 1.7179869184e+10
+sge_host_memory_total_bytes{hostname="ip-172-16-2-251.us-west-2.compute.internal"} 3.4359738368e+10
+# HELP sge_host_swap_used_bytes Number of bytes of swap in use on the host
+# TYPE sge_host_swap_used_bytes gauge
+sge_host_swap_used_bytes{hostname="ip-172-16-2-102.us-west-2.compute.internal"} 0
+sge_host_swap_used_bytes{hostname="ip-172-16-2-251.us-west-2.compute.internal"} 0
+`,
+		},
+		{
+			name: "Qhost failure",
+			fetch: func() (string, error) {
+				return "", errors.New("qmaster unreachable")
+			},
+			metrics: []string{"sge_qhost_up", "sge_host_cpus"},
+			want: `
+# HELP sge_qhost_up Whether qhost was able to run and be parsed (1) or not (0)
+# TYPE sge_qhost_up gauge
+sge_qhost_up 0
+`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			collector := NewHostCollector(DefaultNamespace)
+			collector.fetch = tt.fetch
+
+			if err := testutil.CollectAndCompare(collector, strings.NewReader(tt.want), tt.metrics...); err != nil {
+				t.Errorf("Unexpected host metrics: %s", err)
+			}
+		})
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    float64
+		wantErr bool
+	}{
+		{value: "0.0", want: 0},
+		{value: "512", want: 512},
+		{value: "1.5K", want: 1536},
+		{value: "428.000M", want: 428 * 1024 * 1024},
+		{value: "16.0G", want: 16 * 1024 * 1024 * 1024},
+		{value: "2g", want: 2e9},
+		{value: "", wantErr: true},
+		{value: "lots", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := ParseSize(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseSize() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("ParseSize() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,165 @@
+package gridengine_prometheus
+
+import (
+	"encoding/xml"
+	"fmt"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+//QquotaResult is the root of the XML document produced by qquota -xml
+type QquotaResult struct {
+	XMLName xml.Name    `xml:"qquota_result"`
+	Rules   []QuotaRule `xml:"qquota_rule"`
+}
+
+//QuotaRule is a single resource quota rule along with the filters it currently applies to. Name is the rule set and
+//rule separated by a slash, such as max_slots_per_user/1
+type QuotaRule struct {
+	Name     string       `xml:"name,attr"`
+	Users    []string     `xml:"users"`
+	Projects []string     `xml:"projects"`
+	PEs      []string     `xml:"pes"`
+	Queues   []string     `xml:"queues"`
+	Hosts    []string     `xml:"hosts"`
+	Limits   []QuotaLimit `xml:"limit"`
+}
+
+//QuotaLimit is the limit and current usage of a single resource within a rule
+type QuotaLimit struct {
+	Resource string `xml:"resource,attr"`
+	Limit    string `xml:"limit,attr"`
+	Value    string `xml:"value,attr"`
+}
+
+//RuleSet splits the rule name into the rule set and the rule within it
+func (r QuotaRule) RuleSet() (set string, rule string) {
+	pieces := strings.SplitN(r.Name, "/", 2)
+
+	if len(pieces) == 1 {
+		return pieces[0], ""
+	}
+
+	return pieces[0], pieces[1]
+}
+
+//QuotaCollector reports the limit and usage of every resource quota rule using qquota
+type QuotaCollector struct {
+	Up    *prometheus.Desc
+	Limit *prometheus.Desc
+	Usage *prometheus.Desc
+
+	//Runner runs qquota against the grid engine cell. nil runs it in the environment of the exporter
+	Runner *CommandRunner
+
+	//fetch is how we get the raw qquota XML. Swappable for testing
+	fetch func() (string, error)
+}
+
+//NewQuotaCollector returns a collector that runs qquota on every collection, with metric names prefixed by namespace
+func NewQuotaCollector(namespace string) *QuotaCollector {
+	quotaLabels := []string{
+		"rule_set",
+		"rule",
+		"resource",
+		"user",
+		"project",
+		"pe",
+		"queue",
+		"host",
+	}
+
+	collector := &QuotaCollector{
+		Up: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "qquota_up"),
+			"Whether qquota was able to run and be parsed (1) or not (0)",
+			nil,
+			nil),
+		Limit: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "quota_limit"),
+			"Limit of the resource quota rule for the resource and filters",
+			quotaLabels,
+			nil),
+		Usage: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "quota_usage"),
+			"Current usage counted against the resource quota rule for the resource and filters",
+			quotaLabels,
+			nil),
+	}
+	collector.fetch = collector.qquotaOutput
+
+	return collector
+}
+
+//Describe provides prometheus with descriptions and details (not values) of each metric
+func (collector *QuotaCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- collector.Up
+	ch <- collector.Limit
+	ch <- collector.Usage
+}
+
+//Collect runs qquota and feeds the limit and usage of each rule into the channel
+func (collector *QuotaCollector) Collect(ch chan<- prometheus.Metric) {
+	qr, err := collector.qquota()
+	if err != nil {
+		log.WithError(err).Error("Unable to gather resource quotas from qquota")
+		ch <- prometheus.MustNewConstMetric(collector.Up, prometheus.GaugeValue, 0)
+		return
+	}
+
+	ch <- prometheus.MustNewConstMetric(collector.Up, prometheus.GaugeValue, 1)
+
+	for _, r := range qr.Rules {
+		set, rule := r.RuleSet()
+
+		for _, l := range r.Limits {
+			labels := []string{
+				set,
+				rule,
+				l.Resource,
+				strings.Join(r.Users, ","),
+				strings.Join(r.Projects, ","),
+				strings.Join(r.PEs, ","),
+				strings.Join(r.Queues, ","),
+				strings.Join(r.Hosts, ","),
+			}
+
+			//Unlimited or non numeric limits have nothing useful to report
+			if limit, ok := ParseResourceValue(l.Limit); ok {
+				ch <- prometheus.MustNewConstMetric(collector.Limit, prometheus.GaugeValue, limit, labels...)
+			}
+
+			if usage, ok := ParseResourceValue(l.Value); ok {
+				ch <- prometheus.MustNewConstMetric(collector.Usage, prometheus.GaugeValue, usage, labels...)
+			}
+		}
+	}
+}
+
+func (collector *QuotaCollector) qquota() (QquotaResult, error) {
+	qr := QquotaResult{}
+
+	fetch := collector.fetch
+	if fetch == nil {
+		fetch = collector.qquotaOutput
+	}
+
+	x, err := fetch()
+	if err != nil {
+		return qr, fmt.Errorf("there was an error running qquota: %w", err)
+	}
+
+	err = xml.Unmarshal([]byte(x), &qr)
+	if err != nil {
+		return qr, fmt.Errorf("unable to marshal the qquota XML cleanly into an object: %w", err)
+	}
+
+	return qr, nil
+}
+
+//qquotaOutput runs qquota for every user, as by default it only reports the quotas that apply to the calling user
+func (collector *QuotaCollector) qquotaOutput() (string, error) {
+	return collector.Runner.Output("qquota", "-u", "*", "-xml")
+}
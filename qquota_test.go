@@ -0,0 +1,59 @@
+package gridengine_prometheus
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestQuotaCollector_Collect(t *testing.T) {
+	tests := []struct {
+		name  string
+		fetch func() (string, error)
+		want  string
+	}{
+		{
+			name:  "Quota rules",
+			fetch: fixtureFetch(t, "testdata/qquota.xml"),
+			want: `
+# HELP sge_qquota_up Whether qquota was able to run and be parsed (1) or not (0)
+# TYPE sge_qquota_up gauge
+sge_qquota_up 1
+# HELP sge_quota_limit Limit of the resource quota rule for the resource and filters
+# TYPE sge_quota_limit gauge
+sge_quota_limit{host="",pe="",project="",queue="",resource="slots",rule="1",rule_set="max_slots_per_user",user="asmith"} 20
+sge_quota_limit{host="",pe="",project="",queue="",resource="slots",rule="1",rule_set="max_slots_per_user",user="jdoe"} 20
+sge_quota_limit{host="ip-172-16-2-102.us-west-2.compute.internal",pe="",project="modeling",queue="",resource="h_vmem",rule="nonmem",rule_set="modeling",user=""} 6.8719476736e+10
+# HELP sge_quota_usage Current usage counted against the resource quota rule for the resource and filters
+# TYPE sge_quota_usage gauge
+sge_quota_usage{host="",pe="",project="",queue="",resource="slots",rule="1",rule_set="max_slots_per_user",user="asmith"} 20
+sge_quota_usage{host="",pe="",project="",queue="",resource="slots",rule="1",rule_set="max_slots_per_user",user="jdoe"} 4
+sge_quota_usage{host="ip-172-16-2-102.us-west-2.compute.internal",pe="",project="modeling",queue="",resource="h_vmem",rule="nonmem",rule_set="modeling",user=""} 1.7179869184e+10
+sge_quota_usage{host="ip-172-16-2-102.us-west-2.compute.internal",pe="",project="modeling",queue="",resource="nonmem_licenses",rule="nonmem",rule_set="modeling",user=""} 2
+`,
+		},
+		{
+			name: "Qquota failure",
+			fetch: func() (string, error) {
+				return "", errors.New("qmaster unreachable")
+			},
+			want: `
+# HELP sge_qquota_up Whether qquota was able to run and be parsed (1) or not (0)
+# TYPE sge_qquota_up gauge
+sge_qquota_up 0
+`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			collector := NewQuotaCollector(DefaultNamespace)
+			collector.fetch = tt.fetch
+
+			if err := testutil.CollectAndCompare(collector, strings.NewReader(tt.want)); err != nil {
+				t.Errorf("Unexpected quota metrics: %s", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,200 @@
+package gridengine_prometheus
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/metrumresearchgroup/gogridengine"
+)
+
+//QueueInstance is a single queue instance as qstat reports it. gogridengine decodes the name, slots and load, while the
+//state, the type of each resource and the jobs along with the details gogridengine leaves out of them are decoded in
+//the same pass. Resources and Jobs take the place of the resource and job lists of the gogridengine.QueueList, which
+//are left empty
+type QueueInstance struct {
+	gogridengine.QueueList
+	State     string          `xml:"state"`
+	Resources []QueueResource `xml:"resource"`
+	Jobs      []Job           `xml:"job_list"`
+}
+
+//resource parses the value of the named resource from the resource list of the queue instance
+func (q QueueInstance) resource(name string, parse func(value string) (float64, error)) (float64, error) {
+	for _, r := range q.Resources {
+		if r.Name == name {
+			return parse(r.Value)
+		}
+	}
+
+	return 0, fmt.Errorf("queue instance %s has no %s resource", q.Name, name)
+}
+
+//QueueResource is a single value from the resource list of a queue instance. Type is the two letter code qstat -F
+//reports it with, such as hl for a host load value or gc for a global consumable
+type QueueResource struct {
+	Name  string `xml:"name,attr"`
+	Type  string `xml:"type,attr"`
+	Value string `xml:",chardata"`
+}
+
+//Job is a single job as qstat reports it. gogridengine decodes the number, name, owner, state, priority, slots and
+//tasks, while the details it leaves out are decoded in the same pass
+type Job struct {
+	gogridengine.Job
+	SubmissionTime string `xml:"JB_submission_time"`
+	StartTime      string `xml:"JAT_start_time"`
+	HardQueue      string `xml:"hard_req_queue"`
+	//Resource and parallel environment requests are only reported when qstat is run with -r
+	HardRequests []ResourceRequest `xml:"hard_request"`
+	RequestedPE  ResourceRequest   `xml:"requested_pe"`
+	GrantedPE    ResourceRequest   `xml:"granted_pe"`
+}
+
+//ResourceRequest is a single named resource or parallel environment request attached to a job
+type ResourceRequest struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:",chardata"`
+}
+
+//ErrMissingHost is returned for queue names without a host, such as cluster queues
+var ErrMissingHost = errors.New("queue name has no host")
+
+//ParseQueueInstance splits a queue instance name such as all.q@ip-172-16-2-102.us-west-2.compute.internal into the
+//queue and host. With short set, the domain is stripped from the hostname unless it is an IP address
+func ParseQueueInstance(name string, short bool) (queue string, hostname string, err error) {
+	name = strings.TrimSpace(name)
+
+	pieces := strings.SplitN(name, "@", 2)
+	queue = pieces[0]
+
+	if len(queue) == 0 {
+		return "", "", fmt.Errorf("queue instance %q has no queue", name)
+	}
+
+	if len(pieces) == 1 {
+		return queue, "", ErrMissingHost
+	}
+
+	hostname = pieces[1]
+
+	if len(hostname) == 0 {
+		return queue, "", ErrMissingHost
+	}
+
+	if strings.Contains(hostname, "@") {
+		return queue, "", fmt.Errorf("queue instance %q has more than one host separator", name)
+	}
+
+	if short && net.ParseIP(hostname) == nil {
+		hostname = ShortHostname(hostname)
+	}
+
+	return queue, hostname, nil
+}
+
+//queueInstanceLabels is the queue and hostname a queue instance is reported under. A name without a host, such as a
+//cluster queue, is reported with an empty hostname. Malformed names are still reported so their jobs aren't lost, under
+//the name in full when there is no queue to be had, and the error is returned to be counted
+func queueInstanceLabels(name string, short bool) (queue string, hostname string, err error) {
+	queue, hostname, err = ParseQueueInstance(name, short)
+
+	if errors.Is(err, ErrMissingHost) {
+		return queue, "", nil
+	}
+
+	if len(queue) == 0 {
+		queue = strings.TrimSpace(name)
+	}
+
+	return queue, hostname, err
+}
+
+//ShortHostname strips the domain from a fully qualified hostname
+func ShortHostname(hostname string) string {
+	if i := strings.Index(hostname, "."); i > 0 {
+		return hostname[:i]
+	}
+
+	return hostname
+}
+
+//qstatTimeLayouts are the formats qstat uses for timestamps, which are in the qmaster's local time
+var qstatTimeLayouts = []string{
+	"2006-01-02T15:04:05",
+	"2006-01-02T15:04:05.000",
+}
+
+func parseQstatTime(value string) (time.Time, bool) {
+	value = strings.TrimSpace(value)
+
+	if len(value) == 0 {
+		return time.Time{}, false
+	}
+
+	for _, layout := range qstatTimeLayouts {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, true
+		}
+	}
+
+	return time.Time{}, false
+}
+
+//Submitted is when the job was submitted. Only reported by qstat for pending jobs
+func (j Job) Submitted() (time.Time, bool) {
+	return parseQstatTime(j.SubmissionTime)
+}
+
+//Started is when the job started running. Only reported by qstat for running jobs
+func (j Job) Started() (time.Time, bool) {
+	return parseQstatTime(j.StartTime)
+}
+
+//jobTask identifies a single task of a job. qstat lists a parallel job under every queue instance it runs on
+type jobTask struct {
+	Number int64
+	Task   int64
+}
+
+//task is the task of the job qstat is listing
+func (j Job) task() jobTask {
+	return jobTask{Number: j.JBJobNumber, Task: j.Tasks.TaskID}
+}
+
+//String is the job number and task, such as 13.1
+func (t jobTask) String() string {
+	return strconv.FormatInt(t.Number, 10) + "." + strconv.FormatInt(t.Task, 10)
+}
+
+//QueueStates maps each state flag qstat can report for a queue instance to the name we report it by
+var QueueStates = []struct {
+	Flag string
+	Name string
+}{
+	{Flag: "a", Name: "alarm"},
+	{Flag: "A", Name: "suspend_alarm"},
+	{Flag: "c", Name: "configuration_ambiguous"},
+	{Flag: "C", Name: "calendar_suspended"},
+	{Flag: "d", Name: "disabled"},
+	{Flag: "D", Name: "calendar_disabled"},
+	{Flag: "E", Name: "error"},
+	{Flag: "o", Name: "orphaned"},
+	{Flag: "s", Name: "suspended"},
+	{Flag: "S", Name: "subordinate_suspended"},
+	{Flag: "u", Name: "unknown"},
+}
+
+//QueueStateFlags reports whether each known state is set in a qstat state string such as "au" or "dE"
+func QueueStateFlags(state string) map[string]bool {
+	flags := make(map[string]bool, len(QueueStates))
+
+	for _, s := range QueueStates {
+		flags[s.Name] = strings.Contains(state, s.Flag)
+	}
+
+	return flags
+}
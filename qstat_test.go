@@ -0,0 +1,198 @@
+package gridengine_prometheus
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestParseQueueInstance(t *testing.T) {
+	tests := []struct {
+		name         string
+		queueName    string
+		short        bool
+		wantQueue    string
+		wantHostname string
+		wantErr      bool
+	}{
+		{
+			name:         "Fully qualified",
+			queueName:    "all.q@ip-172-16-2-102.us-west-2.compute.internal",
+			wantQueue:    "all.q",
+			wantHostname: "ip-172-16-2-102.us-west-2.compute.internal",
+		},
+		{
+			name:         "Short hostnames",
+			queueName:    "all.q@ip-172-16-2-102.us-west-2.compute.internal",
+			short:        true,
+			wantQueue:    "all.q",
+			wantHostname: "ip-172-16-2-102",
+		},
+		{
+			name:         "Already short",
+			queueName:    "long.q@node01",
+			short:        true,
+			wantQueue:    "long.q",
+			wantHostname: "node01",
+		},
+		{
+			name:         "IP addresses are left alone",
+			queueName:    "all.q@172.16.2.102",
+			short:        true,
+			wantQueue:    "all.q",
+			wantHostname: "172.16.2.102",
+		},
+		{
+			name:         "Surrounding whitespace",
+			queueName:    " all.q@node01 \n",
+			wantQueue:    "all.q",
+			wantHostname: "node01",
+		},
+		{
+			name:      "Cluster queue without a host",
+			queueName: "all.q",
+			wantQueue: "all.q",
+			wantErr:   true,
+		},
+		{
+			name:      "Empty host",
+			queueName: "all.q@",
+			wantQueue: "all.q",
+			wantErr:   true,
+		},
+		{
+			name:      "Missing queue",
+			queueName: "@node01",
+			wantErr:   true,
+		},
+		{
+			name:      "Empty",
+			queueName: "",
+			wantErr:   true,
+		},
+		{
+			name:      "Too many separators",
+			queueName: "all.q@node01@node02",
+			wantQueue: "all.q",
+			wantErr:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			queue, hostname, err := ParseQueueInstance(tt.queueName, tt.short)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseQueueInstance() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if queue != tt.wantQueue {
+				t.Errorf("ParseQueueInstance() queue = %q, want %q", queue, tt.wantQueue)
+			}
+			if hostname != tt.wantHostname {
+				t.Errorf("ParseQueueInstance() hostname = %q, want %q", hostname, tt.wantHostname)
+			}
+		})
+	}
+}
+
+func TestQueueInstanceLabels(t *testing.T) {
+	tests := []struct {
+		name         string
+		queueName    string
+		wantQueue    string
+		wantHostname string
+		wantErr      bool
+	}{
+		{name: "Queue instance", queueName: "all.q@node01.cluster.local", wantQueue: "all.q", wantHostname: "node01"},
+		{name: "Cluster queue", queueName: "all.q", wantQueue: "all.q"},
+		{name: "Trailing separator", queueName: "all.q@", wantQueue: "all.q"},
+		{name: "Several separators", queueName: "all.q@node01@node02", wantQueue: "all.q", wantErr: true},
+		{name: "No queue", queueName: "@node01", wantQueue: "@node01", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			queue, hostname, err := queueInstanceLabels(tt.queueName, true)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("queueInstanceLabels() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if queue != tt.wantQueue || hostname != tt.wantHostname {
+				t.Errorf("queueInstanceLabels() = %q, %q, want %q, %q", queue, hostname, tt.wantQueue, tt.wantHostname)
+			}
+		})
+	}
+}
+
+func TestGridEngine_CollectQueueNames(t *testing.T) {
+	job := func(number int) string {
+		return fmt.Sprintf(`
+      <job_list state="running">
+        <JB_job_number>%d</JB_job_number>
+        <JB_name>job%[1]d</JB_name>
+        <JB_owner>jdoe</JB_owner>
+        <state>r</state>
+        <slots>1</slots>
+      </job_list>`, number)
+	}
+
+	collector := NewGridEngine(WithSource(SourceFunc(func() (string, error) {
+		return `<?xml version='1.0'?>
+<job_info>
+  <queue_info>
+    <Queue-List>
+      <name>all.q</name>
+      <slots_used>1</slots_used>
+      <slots_resv>0</slots_resv>
+      <slots_total>4</slots_total>
+      <load_avg>0.01000</load_avg>` + job(1) + `
+    </Queue-List>
+    <Queue-List>
+      <name>long.q@node01@node02</name>
+      <slots_used>1</slots_used>
+      <slots_resv>0</slots_resv>
+      <slots_total>2</slots_total>
+      <load_avg>0.01000</load_avg>` + job(2) + `
+    </Queue-List>
+    <Queue-List>
+      <name>all.q@node01</name>
+      <slots_used>0</slots_used>
+      <slots_resv>0</slots_resv>
+      <slots_total>4</slots_total>
+      <load_avg>0.01000</load_avg>
+      <resource name="mem_free" type="hl">1G</resource>
+      <resource name="mem_used" type="hl">1G</resource>
+      <resource name="mem_total" type="hl">2G</resource>
+      <resource name="cpu" type="hl">1.0</resource>
+    </Queue-List>
+  </queue_info>
+  <job_info></job_info>
+</job_info>`, nil
+	})))
+
+	//Instances without a host, and with malformed names, are still reported along with their jobs
+	want := `
+# HELP sge_jobs Number of jobs by owner, queue and state
+# TYPE sge_jobs gauge
+sge_jobs{owner="jdoe",queue="all.q",state="r"} 1
+sge_jobs{owner="jdoe",queue="long.q",state="r"} 1
+# HELP sge_queue_slots Total Number of slots available to the host
+# TYPE sge_queue_slots gauge
+sge_queue_slots{hostname="",queue="all.q"} 4
+sge_queue_slots{hostname="",queue="long.q"} 2
+sge_queue_slots{hostname="node01",queue="all.q"} 4
+`
+
+	if err := testutil.CollectAndCompare(collector, strings.NewReader(want), "sge_queue_slots", "sge_jobs"); err != nil {
+		t.Errorf("Unexpected metrics: %s", err)
+	}
+
+	health := collector.Poller.Health()
+
+	if got := health.Errors[StageQueueName]; got != 1 {
+		t.Errorf("Malformed queue names counted = %v, want 1", got)
+	}
+
+	//Neither instance without a host has host load values to be missing
+	if got := health.Errors[StageResource]; got != 0 {
+		t.Errorf("Missing load values counted = %v, want 0", got)
+	}
+}
@@ -0,0 +1,178 @@
+package gridengine_prometheus
+
+import (
+	"encoding/xml"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+//SchedulerInfo is the root of the XML document produced by qstat -xml -j without a job list. Messages are only
+//present when schedd_job_info is enabled in the scheduler configuration
+type SchedulerInfo struct {
+	XMLName  xml.Name           `xml:"detailed_job_info"`
+	Messages []SchedulerMessage `xml:"messages>element>SME_message_list>element"`
+}
+
+//SchedulerMessage is a single reason the scheduler gave for not dispatching the listed jobs
+type SchedulerMessage struct {
+	JobNumbers []int64 `xml:"MES_job_number_list>ulong_sublist>ULNG_value"`
+	Message    string  `xml:"MES_message"`
+}
+
+//PendingReasonOther is the class for any scheduler message that doesn't match one of the PendingReasons
+const PendingReasonOther string = "other"
+
+//PendingReasons maps fragments of scheduler messages to the class we report them by. Scheduler messages embed
+//queue, host and resource names, so they are normalised into this fixed set to keep cardinality bounded. The first
+//class with a matching fragment wins
+var PendingReasons = []struct {
+	Class     string
+	Fragments []string
+}{
+	{Class: "dependency", Fragments: []string{"dependenc", "predecessor"}},
+	{Class: "hold", Fragments: []string{"hold state", "on hold"}},
+	{Class: "quota", Fragments: []string{"exceeds limit", "resource quota"}},
+	{Class: "user_limit", Fragments: []string{"user limit", "max_u_jobs", "maxujobs", "max_aj_instances", "max_aj_tasks"}},
+	{Class: "access", Fragments: []string{"permission", "access list"}},
+	{Class: "parallel_environment", Fragments: []string{" pe \"", "pe list", "parallel environment"}},
+	{Class: "resources", Fragments: []string{"offers only", "(-l "}},
+	{Class: "load", Fragments: []string{"overload", "load threshold", "alarm"}},
+	{Class: "queue_full", Fragments: []string{"is full"}},
+	{Class: "queue_unavailable", Fragments: []string{"disabled", "suspended", "not available", "unknown"}},
+	{Class: "queue_request", Fragments: []string{"queue list", "any queue"}},
+}
+
+//schedulerNames match the parts of scheduler messages that name something on the cluster: quoted queue, host, PE and
+//rule names, resource requests and the resource values a host or queue offers. They are blanked out before
+//classifying so a name such as hold.q or alarm01 can't be mistaken for a reason
+var schedulerNames = []struct {
+	pattern     *regexp.Regexp
+	replacement string
+}{
+	{pattern: regexp.MustCompile(`"[^"]*"`), replacement: `""`},
+	{pattern: regexp.MustCompile(`\(-l [^)]*\)`), replacement: `(-l )`},
+	{pattern: regexp.MustCompile(`offers only \S+`), replacement: `offers only `},
+}
+
+//ClassifyPendingReason normalises a scheduler message into one of the PendingReasons classes, or PendingReasonOther
+func ClassifyPendingReason(message string) string {
+	for _, name := range schedulerNames {
+		message = name.pattern.ReplaceAllString(message, name.replacement)
+	}
+
+	message = strings.ToLower(message)
+
+	for _, r := range PendingReasons {
+		for _, f := range r.Fragments {
+			if strings.Contains(message, f) {
+				return r.Class
+			}
+		}
+	}
+
+	return PendingReasonOther
+}
+
+//PendingReasonCollector reports why pending jobs aren't running using the scheduler messages from qstat -j
+type PendingReasonCollector struct {
+	Up     *prometheus.Desc
+	Reason *prometheus.Desc
+
+	//Runner runs qstat -j against the grid engine cell. nil runs it in the environment of the exporter
+	Runner *CommandRunner
+
+	//fetch is how we get the raw qstat -j XML. Swappable for testing
+	fetch func() (string, error)
+}
+
+//NewPendingReasonCollector returns a collector that runs qstat -j on every collection, with metric names prefixed by
+//namespace
+func NewPendingReasonCollector(namespace string) *PendingReasonCollector {
+	collector := &PendingReasonCollector{
+		Up: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "pending_reasons_up"),
+			"Whether qstat -j was able to run and be parsed (1) or not (0)",
+			nil,
+			nil),
+		Reason: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "job_pending_reason"),
+			"Number of scheduler messages of the given class explaining why a pending job is not running",
+			[]string{"job_number", "reason_class"},
+			nil),
+	}
+	collector.fetch = collector.schedulerOutput
+
+	return collector
+}
+
+//Describe provides prometheus with descriptions and details (not values) of each metric
+func (collector *PendingReasonCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- collector.Up
+	ch <- collector.Reason
+}
+
+//Collect runs qstat -j and feeds the classified reasons for each pending job into the channel
+func (collector *PendingReasonCollector) Collect(ch chan<- prometheus.Metric) {
+	si, err := collector.schedulerInfo()
+	if err != nil {
+		log.WithError(err).Error("Unable to gather pending job reasons from qstat -j")
+		ch <- prometheus.MustNewConstMetric(collector.Up, prometheus.GaugeValue, 0)
+		return
+	}
+
+	ch <- prometheus.MustNewConstMetric(collector.Up, prometheus.GaugeValue, 1)
+
+	for key, count := range si.pendingReasons() {
+		ch <- prometheus.MustNewConstMetric(collector.Reason, prometheus.GaugeValue, count, strconv.FormatInt(key.JobNumber, 10), key.Class)
+	}
+}
+
+type pendingReasonKey struct {
+	JobNumber int64
+	Class     string
+}
+
+//pendingReasons counts the messages for each job by class
+func (si SchedulerInfo) pendingReasons() map[pendingReasonKey]float64 {
+	reasons := make(map[pendingReasonKey]float64)
+
+	for _, m := range si.Messages {
+		class := ClassifyPendingReason(m.Message)
+
+		for _, j := range m.JobNumbers {
+			reasons[pendingReasonKey{JobNumber: j, Class: class}]++
+		}
+	}
+
+	return reasons
+}
+
+func (collector *PendingReasonCollector) schedulerInfo() (SchedulerInfo, error) {
+	si := SchedulerInfo{}
+
+	fetch := collector.fetch
+	if fetch == nil {
+		fetch = collector.schedulerOutput
+	}
+
+	x, err := fetch()
+	if err != nil {
+		return si, fmt.Errorf("there was an error running qstat -j: %w", err)
+	}
+
+	err = xml.Unmarshal([]byte(x), &si)
+	if err != nil {
+		return si, fmt.Errorf("unable to marshal the qstat -j XML cleanly into an object: %w", err)
+	}
+
+	return si, nil
+}
+
+func (collector *PendingReasonCollector) schedulerOutput() (string, error) {
+	return collector.Runner.Output("qstat", "-xml", "-j")
+}
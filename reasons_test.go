@@ -0,0 +1,94 @@
+package gridengine_prometheus
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestClassifyPendingReason(t *testing.T) {
+	tests := []struct {
+		name    string
+		message string
+		want    string
+	}{
+		{message: `(-l h_vmem=64G) cannot run at host "node01" because it offers only hc:h_vmem=16.000G`, want: "resources"},
+		{message: `cannot run in queue instance "all.q@node01" because it is disabled`, want: "queue_unavailable"},
+		{message: `queue instance "all.q@node01" dropped because it is temporarily not available`, want: "queue_unavailable"},
+		{message: `queue instance "all.q@node01" dropped because it is full`, want: "queue_full"},
+		{message: `queue instance "all.q@node01" dropped because it is overloaded: np_load_avg=1.75 (= 1.75 + 0.50 * 0.00 with nproc=4) >= 1.75`, want: "load"},
+		{message: `cannot run in queue "long.q" because it is not contained in its hard queue list (-q)`, want: "queue_request"},
+		{message: `cannot run in PE "smp" because it only offers 2 slots`, want: "parallel_environment"},
+		{message: `cannot run because it exceeds limit "asmith/////" in rule "max_slots_per_user/1"`, want: "quota"},
+		{message: `job dropped because of job dependencies`, want: "dependency"},
+		{message: `job is in hold state`, want: "hold"},
+		{message: `job dropped because of user limitations`, want: "user_limit"},
+		{message: `has no permission for cluster queue "all.q"`, want: "access"},
+		{message: ``, want: "other"},
+		//Names on the cluster that look like a reason are ignored
+		{name: "Queue named hold", message: `queue instance "hold.q@node01" dropped because it is full`, want: "queue_full"},
+		{name: "Host named alarm", message: `cannot run at host "alarm01" because it offers only hc:h_vmem=16.000G`, want: "resources"},
+		{name: "Queue named unknown", message: `cannot run in queue "unknown.q" because it is not contained in its hard queue list (-q)`, want: "queue_request"},
+		{name: "Load threshold isn't a hold", message: `cannot run in queue "all.q" because it exceeds load threshold`, want: "load"},
+		{name: "Resource named hold", message: `(-l hold_license=1) cannot run globally because it offers only gc:hold_license=0.000000`, want: "resources"},
+	}
+	for _, tt := range tests {
+		name := tt.name
+		if len(name) == 0 {
+			name = tt.want
+		}
+
+		t.Run(name, func(t *testing.T) {
+			if got := ClassifyPendingReason(tt.message); got != tt.want {
+				t.Errorf("ClassifyPendingReason(%q) = %v, want %v", tt.message, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPendingReasonCollector_Collect(t *testing.T) {
+	tests := []struct {
+		name  string
+		fetch func() (string, error)
+		want  string
+	}{
+		{
+			name:  "Scheduler messages",
+			fetch: fixtureFetch(t, "testdata/qstat_j.xml"),
+			want: `
+# HELP sge_job_pending_reason Number of scheduler messages of the given class explaining why a pending job is not running
+# TYPE sge_job_pending_reason gauge
+sge_job_pending_reason{job_number="15",reason_class="queue_unavailable"} 1
+sge_job_pending_reason{job_number="15",reason_class="resources"} 2
+sge_job_pending_reason{job_number="16",reason_class="queue_unavailable"} 1
+sge_job_pending_reason{job_number="16",reason_class="quota"} 1
+# HELP sge_pending_reasons_up Whether qstat -j was able to run and be parsed (1) or not (0)
+# TYPE sge_pending_reasons_up gauge
+sge_pending_reasons_up 1
+`,
+		},
+		{
+			name: "Qstat failure",
+			fetch: func() (string, error) {
+				return "", errors.New("qmaster unreachable")
+			},
+			want: `
+# HELP sge_pending_reasons_up Whether qstat -j was able to run and be parsed (1) or not (0)
+# TYPE sge_pending_reasons_up gauge
+sge_pending_reasons_up 0
+`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			collector := NewPendingReasonCollector(DefaultNamespace)
+			collector.fetch = tt.fetch
+
+			if err := testutil.CollectAndCompare(collector, strings.NewReader(tt.want)); err != nil {
+				t.Errorf("Unexpected pending reason metrics: %s", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,327 @@
+package gridengine_prometheus
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+//recordingLayout is the UTC timestamp in the name of each recording. It has a fixed width so the recordings sort by
+//name in the order they were taken
+const recordingLayout = "20060102T150405.000000000Z"
+
+const (
+	recordingPrefix = "qstat-"
+	recordingSuffix = ".xml"
+)
+
+//RecordingName is the file name a qstat payload fetched at the provided time is saved as
+func RecordingName(at time.Time) string {
+	return recordingPrefix + at.UTC().Format(recordingLayout) + recordingSuffix
+}
+
+//Recorder saves every raw qstat payload into a directory so the exact output behind a set of metrics can be looked
+//at or replayed later
+type Recorder struct {
+	Dir string
+	//MaxFiles is how many recordings are kept. 0 is unlimited
+	MaxFiles int
+	//MaxAge is how long recordings are kept. 0 is forever
+	MaxAge time.Duration
+
+	//now is the current time. Swappable for testing
+	now func() time.Time
+}
+
+//NewRecorder returns a recorder saving into dir, keeping at most maxFiles recordings no older than maxAge
+func NewRecorder(dir string, maxFiles int, maxAge time.Duration) *Recorder {
+	return &Recorder{
+		Dir:      dir,
+		MaxFiles: maxFiles,
+		MaxAge:   maxAge,
+		now:      time.Now,
+	}
+}
+
+//Wrap returns a source that records every payload the source returns successfully. A source that can stream still
+//does, with the payload written to the recording as it is read. Failing to record is logged rather than failing the
+//fetch
+func (r *Recorder) Wrap(source Source) Source {
+	recorded := recordedSource{
+		source:   source,
+		recorder: r,
+	}
+
+	if s, ok := source.(StreamSource); ok {
+		return recordedStreamSource{
+			recordedSource: recorded,
+			stream:         s,
+		}
+	}
+
+	return recorded
+}
+
+//Record saves the payload, writing to a temporary file first so a replay never picks up a half written recording, and
+//then removes any recordings past the retention limits
+func (r *Recorder) Record(payload string) error {
+	rec, err := r.create()
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.WriteString(rec, payload); err != nil {
+		rec.abandon()
+		return err
+	}
+
+	return rec.finish()
+}
+
+//create starts a recording taken now
+func (r *Recorder) create() (*recording, error) {
+	now := time.Now
+	if r.now != nil {
+		now = r.now
+	}
+
+	at := now()
+	path := filepath.Join(r.Dir, RecordingName(at))
+
+	file, err := os.Create(path + ".tmp")
+	if err != nil {
+		return nil, err
+	}
+
+	return &recording{
+		recorder: r,
+		file:     file,
+		path:     path,
+		at:       at,
+	}, nil
+}
+
+//recording is a payload being written to a temporary file, which is only renamed into place once it is complete
+type recording struct {
+	recorder *Recorder
+	file     *os.File
+	path     string
+	at       time.Time
+	//err is the first failure to write, after which the rest of the payload is thrown away
+	err error
+}
+
+//Write never fails, so that failing to record never fails the read it is teed from. Any failure is instead returned by
+//finish
+func (rec *recording) Write(p []byte) (int, error) {
+	if rec.err == nil {
+		_, rec.err = rec.file.Write(p)
+	}
+
+	return len(p), nil
+}
+
+//finish renames the complete recording into place and removes any recordings past the retention limits
+func (rec *recording) finish() error {
+	err := rec.file.Close()
+	if rec.err != nil {
+		err = rec.err
+	}
+
+	if err != nil {
+		os.Remove(rec.file.Name())
+		return err
+	}
+
+	if err := os.Rename(rec.file.Name(), rec.path); err != nil {
+		return err
+	}
+
+	return rec.recorder.prune(rec.at)
+}
+
+//abandon removes the unfinished recording
+func (rec *recording) abandon() {
+	rec.file.Close()
+	os.Remove(rec.file.Name())
+}
+
+//recordedSource records every payload fetched from the source
+type recordedSource struct {
+	source   Source
+	recorder *Recorder
+}
+
+//Fetch fetches the payload from the source, and records it if successful
+func (s recordedSource) Fetch() (string, error) {
+	out, err := s.source.Fetch()
+	if err != nil {
+		return out, err
+	}
+
+	if err := s.recorder.Record(out); err != nil {
+		log.WithError(err).Errorf("Unable to record qstat output into %s", s.recorder.Dir)
+	}
+
+	return out, nil
+}
+
+//recordedStreamSource records every payload from a source that can stream, without giving up on streaming it
+type recordedStreamSource struct {
+	recordedSource
+	stream StreamSource
+}
+
+//Stream tees the stream from the source into a new recording
+func (s recordedStreamSource) Stream() (io.ReadCloser, error) {
+	stream, err := s.stream.Stream()
+	if err != nil {
+		return nil, err
+	}
+
+	rec, err := s.recorder.create()
+	if err != nil {
+		log.WithError(err).Errorf("Unable to record qstat output into %s", s.recorder.Dir)
+		return stream, nil
+	}
+
+	return &recordingStream{
+		Reader:    io.TeeReader(stream, rec),
+		stream:    stream,
+		recording: rec,
+	}, nil
+}
+
+//recordingStream is a stream being written to a recording as it is read
+type recordingStream struct {
+	io.Reader
+	stream    io.ReadCloser
+	recording *recording
+}
+
+//Close reads whatever is left of the stream into the recording, so that it holds the exact payload even when decoding
+//stopped short of the end, and then keeps the recording only if the stream was produced successfully
+func (s *recordingStream) Close() error {
+	_, drainErr := io.Copy(io.Discard, s.Reader)
+
+	if err := s.stream.Close(); err != nil {
+		s.recording.abandon()
+		return err
+	}
+
+	if drainErr != nil {
+		s.recording.abandon()
+		log.WithError(drainErr).Errorf("Unable to record qstat output into %s", s.recording.recorder.Dir)
+		return nil
+	}
+
+	if err := s.recording.finish(); err != nil {
+		log.WithError(err).Errorf("Unable to record qstat output into %s", s.recording.recorder.Dir)
+	}
+
+	return nil
+}
+
+//prune removes the recordings that are too old, and then the oldest of those left until there are no more than
+//MaxFiles
+func (r *Recorder) prune(now time.Time) error {
+	recordings, err := Recordings(r.Dir)
+	if err != nil {
+		return err
+	}
+
+	expired := make([]string, 0)
+
+	for len(recordings) > 0 {
+		oldest := recordings[0]
+
+		taken, err := time.Parse(recordingLayout, strings.TrimSuffix(strings.TrimPrefix(filepath.Base(oldest), recordingPrefix), recordingSuffix))
+		tooOld := err == nil && r.MaxAge > 0 && now.Sub(taken) > r.MaxAge
+		tooMany := r.MaxFiles > 0 && len(recordings) > r.MaxFiles
+
+		if !tooOld && !tooMany {
+			break
+		}
+
+		expired = append(expired, oldest)
+		recordings = recordings[1:]
+	}
+
+	for _, path := range expired {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+//Recordings lists the recordings in dir, oldest first
+func Recordings(dir string) ([]string, error) {
+	recordings, err := filepath.Glob(filepath.Join(dir, recordingPrefix+"*"+recordingSuffix))
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Strings(recordings)
+
+	return recordings, nil
+}
+
+//Replay serves a directory of saved qstat payloads, one per fetch, in the order they were recorded. Once every payload
+//has been served it starts again from the first
+type Replay struct {
+	Files []string
+
+	mutex sync.Mutex
+	next  int
+}
+
+//NewReplay returns a replay of the recordings in dir. Any other XML files in dir are replayed too, in name order, so
+//payloads attached to bug reports can be replayed without renaming them
+func NewReplay(dir string) (*Replay, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*"+recordingSuffix))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(files) == 0 {
+		return nil, fmt.Errorf("there are no qstat recordings to replay in %s", dir)
+	}
+
+	sort.Strings(files)
+
+	return &Replay{
+		Files: files,
+	}, nil
+}
+
+//Fetch returns the next payload
+func (r *Replay) Fetch() (string, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if len(r.Files) == 0 {
+		return "", errors.New("there are no qstat recordings to replay")
+	}
+
+	if r.next >= len(r.Files) {
+		log.Infof("Replayed all %d qstat recordings. Starting again from the first", len(r.Files))
+		r.next = 0
+	}
+
+	file := r.Files[r.next]
+	r.next++
+
+	out, err := ioutil.ReadFile(file)
+	return string(out), err
+}
@@ -0,0 +1,283 @@
+package gridengine_prometheus
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+)
+
+func TestRecorder_Record(t *testing.T) {
+	start := time.Date(2026, 10, 18, 4, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		maxFiles int
+		maxAge   time.Duration
+		//records is how many payloads are recorded, a minute apart
+		records int
+		want    []string
+	}{
+		{
+			name:    "Unlimited",
+			records: 3,
+			want: []string{
+				"qstat-20261018T040000.000000000Z.xml",
+				"qstat-20261018T040100.000000000Z.xml",
+				"qstat-20261018T040200.000000000Z.xml",
+			},
+		},
+		{
+			name:     "Max files",
+			maxFiles: 2,
+			records:  4,
+			want: []string{
+				"qstat-20261018T040200.000000000Z.xml",
+				"qstat-20261018T040300.000000000Z.xml",
+			},
+		},
+		{
+			name:    "Max age",
+			maxAge:  90 * time.Second,
+			records: 4,
+			want: []string{
+				"qstat-20261018T040200.000000000Z.xml",
+				"qstat-20261018T040300.000000000Z.xml",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			now := start
+
+			recorder := NewRecorder(dir, tt.maxFiles, tt.maxAge)
+			recorder.now = func() time.Time {
+				return now
+			}
+
+			for i := 0; i < tt.records; i++ {
+				if err := recorder.Record("<job_info/>"); err != nil {
+					t.Fatal(err)
+				}
+				now = now.Add(time.Minute)
+			}
+
+			recordings, err := Recordings(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := make([]string, 0)
+			for _, r := range recordings {
+				got = append(got, filepath.Base(r))
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Recordings() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRecorder_Wrap(t *testing.T) {
+	dir := t.TempDir()
+	recorder := NewRecorder(dir, 0, 0)
+
+	source := recorder.Wrap(SourceFunc(func() (string, error) {
+		return "<job_info/>", nil
+	}))
+
+	if out, err := source.Fetch(); err != nil || out != "<job_info/>" {
+		t.Fatalf("Fetch() = %q, %v", out, err)
+	}
+
+	failing := recorder.Wrap(SourceFunc(func() (string, error) {
+		return "", errors.New("qstat: command not found")
+	}))
+
+	if _, err := failing.Fetch(); err == nil {
+		t.Fatal("Expected the fetch error to be passed on")
+	}
+
+	recordings, _ := Recordings(dir)
+	if len(recordings) != 1 {
+		t.Fatalf("Expected only the successful fetch to be recorded, found %d recordings", len(recordings))
+	}
+
+	content, _ := ioutil.ReadFile(recordings[0])
+	if string(content) != "<job_info/>" {
+		t.Errorf("Recorded %q, want the raw payload", string(content))
+	}
+}
+
+//closingSource streams a payload, failing with err once the stream is closed
+type closingSource struct {
+	payload string
+	err     error
+}
+
+func (s closingSource) Fetch() (string, error) {
+	return s.payload, s.err
+}
+
+func (s closingSource) Stream() (io.ReadCloser, error) {
+	return closingStream{Reader: strings.NewReader(s.payload), err: s.err}, nil
+}
+
+type closingStream struct {
+	io.Reader
+	err error
+}
+
+func (s closingStream) Close() error {
+	return s.err
+}
+
+func TestRecorder_WrapStream(t *testing.T) {
+	const payload = "<job_info><queue_info></queue_info><job_info></job_info></job_info>\n"
+
+	dir := t.TempDir()
+	recorder := NewRecorder(dir, 0, 0)
+
+	source, ok := recorder.Wrap(closingSource{payload: payload}).(StreamSource)
+	if !ok {
+		t.Fatal("Expected a source that can stream to still stream once recorded")
+	}
+
+	stream, err := source.Stream()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//The decoder stops at the end of the document, so whatever follows is only read into the recording on close
+	if _, err := io.ReadFull(stream, make([]byte, 10)); err != nil {
+		t.Fatal(err)
+	}
+	if err := stream.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	failing, _ := recorder.Wrap(closingSource{payload: "<job_", err: errors.New("qstat: signal: killed")}).(StreamSource)
+
+	stream, err = failing.Stream()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := stream.Close(); err == nil {
+		t.Fatal("Expected the stream error to be passed on")
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*"))
+	if len(files) != 1 {
+		t.Fatalf("Expected only the successful stream to be recorded, found %v", files)
+	}
+
+	content, _ := ioutil.ReadFile(files[0])
+	if string(content) != payload {
+		t.Errorf("Recorded %q, want the raw payload", string(content))
+	}
+}
+
+func TestReplay(t *testing.T) {
+	if _, err := NewReplay(t.TempDir()); err == nil {
+		t.Error("Expected an error replaying an empty directory")
+	}
+
+	dir := t.TempDir()
+	payloads := []string{"<first/>", "<second/>"}
+	for i, p := range payloads {
+		name := RecordingName(time.Date(2026, 10, 18, 4, i, 0, 0, time.UTC))
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(p), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	replay, err := NewReplay(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//Once the recordings run out they are served again from the first
+	for _, want := range []string{"<first/>", "<second/>", "<first/>", "<second/>"} {
+		got, err := replay.Fetch()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("Fetch() = %q, want %q", got, want)
+		}
+	}
+}
+
+func TestReplayPoller(t *testing.T) {
+	qstat, err := ioutil.ReadFile("testdata/qstat.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorded := t.TempDir()
+	poller := NewPoller(0)
+	poller.Source = FileSource{Path: "testdata/qstat.xml"}
+	poller.Recorder = NewRecorder(recorded, 0, 0)
+
+	if err := poller.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+
+	replay, err := NewReplay(recorded)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if content, _ := replay.Fetch(); content != string(qstat) {
+		t.Fatal("Expected the recording to hold the exact qstat payload")
+	}
+
+	//A fresh replay of the recordings reports the same metrics as the original qstat output
+	replay, _ = NewReplay(recorded)
+
+	original := NewGridEngine(WithSource(FileSource{Path: "testdata/qstat.xml"}))
+	replayed := NewGridEngine(WithSource(replay))
+
+	metrics := []string{"sge_queue_slots", "sge_job_running", "sge_resource_value"}
+
+	want := collectText(t, original, metrics...)
+	if len(want) == 0 {
+		t.Fatal("Expected metrics from the original qstat output")
+	}
+	if got := collectText(t, replayed, metrics...); got != want {
+		t.Errorf("Replayed metrics differ from the original.\ngot:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+//collectText renders the named metrics of the collector in the text format
+func collectText(t *testing.T, collector prometheus.Collector, names ...string) string {
+	registry := prometheus.NewPedanticRegistry()
+	registry.MustRegister(collector)
+
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var text strings.Builder
+	for _, family := range families {
+		for _, name := range names {
+			if family.GetName() == name {
+				if _, err := expfmt.MetricFamilyToText(&text, family); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+	}
+
+	return text.String()
+}
@@ -0,0 +1,136 @@
+package gridengine_prometheus
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+//MemoryRequestResources are the hard resource requests reported as requested memory. SGE treats each of them as
+//per slot
+var MemoryRequestResources = []string{"h_vmem", "s_vmem", "mem_free", "virtual_free"}
+
+//RuntimeRequestResource is the hard resource request reported as requested run time
+const RuntimeRequestResource string = "h_rt"
+
+//JobRequests are the parsed resource and parallel environment requests of a single job
+type JobRequests struct {
+	//Memory is the per slot memory requested in bytes, keyed by resource
+	Memory map[string]float64
+	//Runtime is the requested run time in seconds, if HasRuntime is set
+	Runtime    float64
+	HasRuntime bool
+	//PE is the parallel environment requested, if any, and the number of slots from it
+	PE      string
+	PESlots float64
+}
+
+//Requests parses the job's hard resource requests and parallel environment. Unlimited (INFINITY) requests and values
+//that can't be parsed are left out, and the first parse error is returned alongside everything that could be parsed
+func (j Job) Requests() (JobRequests, error) {
+	requests := JobRequests{
+		Memory: make(map[string]float64),
+	}
+
+	var first error
+	fail := func(err error) {
+		if first == nil {
+			first = err
+		}
+	}
+
+	for _, r := range j.HardRequests {
+		switch {
+		case r.Name == RuntimeRequestResource:
+			seconds, err := ParseTime(r.Value)
+			if err != nil {
+				fail(fmt.Errorf("invalid %s request for job %d: %w", r.Name, j.JBJobNumber, err))
+				continue
+			}
+			if math.IsInf(seconds, 1) {
+				continue
+			}
+			requests.Runtime = seconds
+			requests.HasRuntime = true
+		case isMemoryRequest(r.Name):
+			bytes, err := ParseSize(r.Value)
+			if err != nil {
+				fail(fmt.Errorf("invalid %s request for job %d: %w", r.Name, j.JBJobNumber, err))
+				continue
+			}
+			if math.IsInf(bytes, 1) {
+				continue
+			}
+			requests.Memory[r.Name] = bytes
+		}
+	}
+
+	//Running jobs have been granted a definite number of slots, pending ones may have asked for a range
+	pe := j.GrantedPE
+	if len(pe.Name) == 0 {
+		pe = j.RequestedPE
+	}
+
+	if len(pe.Name) > 0 {
+		slots, err := peSlots(pe.Value)
+		if err != nil {
+			fail(fmt.Errorf("invalid parallel environment request for job %d: %w", j.JBJobNumber, err))
+		} else {
+			requests.PE = pe.Name
+			requests.PESlots = slots
+		}
+	}
+
+	return requests, first
+}
+
+func isMemoryRequest(name string) bool {
+	for _, m := range MemoryRequestResources {
+		if m == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+//peSlots is the smallest number of slots in a parallel environment slot range such as 4, 2-8, 2- or -8
+func peSlots(value string) (float64, error) {
+	lower := strings.TrimSpace(strings.SplitN(value, "-", 2)[0])
+
+	if len(lower) == 0 {
+		return 1, nil
+	}
+
+	return strconv.ParseFloat(lower, 64)
+}
+
+//collectJobRequests emits the resource requests of a single job
+func (collector *GridEngine) collectJobRequests(ch chan<- prometheus.Metric, entry jobEntry) {
+	j := entry.Job
+	requests := entry.Requests
+	labels := []string{
+		entry.Hostname,
+		entry.Queue,
+		j.JobName,
+		j.JobOwner,
+		strconv.FormatInt(j.JBJobNumber, 10),
+		strconv.Itoa(int(j.Tasks.TaskID)),
+		j.State,
+	}
+
+	for resource, bytes := range requests.Memory {
+		ch <- prometheus.MustNewConstMetric(collector.JobMemoryRequest, prometheus.GaugeValue, bytes, append(labels, resource)...)
+	}
+
+	if requests.HasRuntime {
+		ch <- prometheus.MustNewConstMetric(collector.JobRuntimeRequest, prometheus.GaugeValue, requests.Runtime, labels...)
+	}
+
+	if len(requests.PE) > 0 {
+		ch <- prometheus.MustNewConstMetric(collector.JobPESlots, prometheus.GaugeValue, requests.PESlots, append(labels, requests.PE)...)
+	}
+}
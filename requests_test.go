@@ -0,0 +1,180 @@
+package gridengine_prometheus
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestJob_Requests(t *testing.T) {
+	tests := []struct {
+		name    string
+		job     Job
+		want    JobRequests
+		wantErr bool
+	}{
+		{
+			name: "Memory and run time",
+			job: Job{
+				HardRequests: []ResourceRequest{
+					{Name: "h_vmem", Value: "4G"},
+					{Name: "mem_free", Value: "512M"},
+					{Name: "h_rt", Value: "1:30:00"},
+					{Name: "arch", Value: "lx-amd64"},
+				},
+			},
+			want: JobRequests{
+				Memory: map[string]float64{
+					"h_vmem":   4 * 1024 * 1024 * 1024,
+					"mem_free": 512 * 1024 * 1024,
+				},
+				Runtime:    5400,
+				HasRuntime: true,
+			},
+		},
+		{
+			name: "Granted parallel environment wins over the requested range",
+			job: Job{
+				RequestedPE: ResourceRequest{Name: "smp", Value: "2-8"},
+				GrantedPE:   ResourceRequest{Name: "smp", Value: "6"},
+			},
+			want: JobRequests{
+				Memory:  map[string]float64{},
+				PE:      "smp",
+				PESlots: 6,
+			},
+		},
+		{
+			name: "Requested parallel environment range",
+			job: Job{
+				RequestedPE: ResourceRequest{Name: "mpi", Value: "-8"},
+			},
+			want: JobRequests{
+				Memory:  map[string]float64{},
+				PE:      "mpi",
+				PESlots: 1,
+			},
+		},
+		{
+			name: "Unlimited requests are skipped",
+			job: Job{
+				HardRequests: []ResourceRequest{
+					{Name: "h_vmem", Value: "INFINITY"},
+					{Name: "h_rt", Value: "infinity"},
+				},
+			},
+			want: JobRequests{
+				Memory: map[string]float64{},
+			},
+		},
+		{
+			name: "Invalid values are skipped",
+			job: Job{
+				HardRequests: []ResourceRequest{
+					{Name: "h_vmem", Value: "lots"},
+					{Name: "h_rt", Value: "3600"},
+				},
+			},
+			want: JobRequests{
+				Memory:     map[string]float64{},
+				Runtime:    3600,
+				HasRuntime: true,
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.job.Requests()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Requests() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Requests() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseTime(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    float64
+		wantErr bool
+	}{
+		{value: "3600", want: 3600},
+		{value: "1:00:00", want: 3600},
+		{value: "90:00", want: 5400},
+		{value: "::30", want: 30},
+		{value: "0:0:1.5", want: 1.5},
+		{value: "", wantErr: true},
+		{value: "1:2:3:4", wantErr: true},
+		{value: "INFINITY", want: math.Inf(1)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := ParseTime(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseTime() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("ParseTime() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGridEngine_CollectJobRequests(t *testing.T) {
+	collector := NewGridEngine(WithSource(FileSource{Path: "testdata/qstat.xml"}), WithMasterHostname(testMaster))
+
+	want := fmt.Sprintf(`
+# HELP sge_job_parallel_environment_slots Number of slots granted to, or at least requested by, the job from its parallel environment
+# TYPE sge_job_parallel_environment_slots gauge
+sge_job_parallel_environment_slots{hostname="ip-172-16-2-102.us-west-2.compute.internal",job_number="17",name="Run6",owner="jdoe",pe="mpi",queue="all.q",state="r",task_id="0"} 4
+sge_job_parallel_environment_slots{hostname="ip-172-16-2-251.us-west-2.compute.internal",job_number="17",name="Run6",owner="jdoe",pe="mpi",queue="all.q",state="r",task_id="0"} 4
+sge_job_parallel_environment_slots{hostname=%[1]q,job_number="16",name="Run5",owner="asmith",pe="smp",queue="pending",state="Eqw",task_id="0"} 2
+# HELP sge_job_runtime_request_seconds Run time limit (h_rt) requested by the job
+# TYPE sge_job_runtime_request_seconds gauge
+sge_job_runtime_request_seconds{hostname="ip-172-16-2-102.us-west-2.compute.internal",job_number="13",name="Run2",owner="jdoe",queue="all.q",state="r",task_id="0"} 3600
+sge_job_runtime_request_seconds{hostname="ip-172-16-2-102.us-west-2.compute.internal",job_number="17",name="Run6",owner="jdoe",queue="all.q",state="r",task_id="0"} 7200
+sge_job_runtime_request_seconds{hostname="ip-172-16-2-251.us-west-2.compute.internal",job_number="17",name="Run6",owner="jdoe",queue="all.q",state="r",task_id="0"} 7200
+sge_job_runtime_request_seconds{hostname=%[1]q,job_number="15",name="Run4",owner="jdoe",queue="pending",state="qw",task_id="0"} 7200
+# HELP sge_jobs_parallel_environment_slots Slots granted to, or at least requested by, jobs from each parallel environment by owner, queue and state
+# TYPE sge_jobs_parallel_environment_slots gauge
+sge_jobs_parallel_environment_slots{owner="asmith",pe="smp",queue="pending",state="Eqw"} 2
+sge_jobs_parallel_environment_slots{owner="jdoe",pe="mpi",queue="all.q",state="r"} 4
+# HELP sge_jobs_memory_request_bytes Memory requested across every slot of jobs by owner, queue, state and resource
+# TYPE sge_jobs_memory_request_bytes gauge
+sge_jobs_memory_request_bytes{owner="asmith",queue="pending",resource="h_vmem",state="Eqw"} 4.294967296e+09
+sge_jobs_memory_request_bytes{owner="jdoe",queue="all.q",resource="h_vmem",state="r"} 8.589934592e+09
+sge_jobs_memory_request_bytes{owner="jdoe",queue="pending",resource="h_vmem",state="qw"} 8.589934592e+09
+sge_jobs_memory_request_bytes{owner="jdoe",queue="pending",resource="mem_free",state="qw"} 2.147483648e+09
+# HELP sge_jobs_runtime_request_seconds Sum of the run time limits (h_rt) requested by jobs by owner, queue and state
+# TYPE sge_jobs_runtime_request_seconds gauge
+sge_jobs_runtime_request_seconds{owner="asmith",queue="all.q",state="r"} 0
+sge_jobs_runtime_request_seconds{owner="asmith",queue="pending",state="Eqw"} 0
+sge_jobs_runtime_request_seconds{owner="jdoe",queue="all.q",state="r"} 10800
+sge_jobs_runtime_request_seconds{owner="jdoe",queue="pending",state="qw"} 7200
+`, testMaster)
+
+	metrics := []string{
+		"sge_job_parallel_environment_slots",
+		"sge_job_runtime_request_seconds",
+		"sge_jobs_memory_request_bytes",
+		"sge_jobs_parallel_environment_slots",
+		"sge_jobs_runtime_request_seconds",
+	}
+
+	if err := testutil.CollectAndCompare(collector, strings.NewReader(want), metrics...); err != nil {
+		t.Errorf("Unexpected job request metrics: %s", err)
+	}
+
+	if got := testutil.CollectAndCount(collector, "sge_job_memory_request_bytes"); got != 6 {
+		t.Errorf("Expected 6 per job memory requests, got %d", got)
+	}
+}
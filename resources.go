@@ -0,0 +1,77 @@
+package gridengine_prometheus
+
+import (
+	"math"
+	"path"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+//ParseResourceValue converts a resource value into a number, handling SGE size suffixes and time formats. Values
+//that aren't numeric, such as arch or hostname, and unlimited values are reported as not ok
+func ParseResourceValue(value string) (float64, bool) {
+	var parsed float64
+	var err error
+
+	if strings.Contains(value, ":") {
+		parsed, err = ParseTime(value)
+	} else {
+		parsed, err = ParseSize(value)
+	}
+
+	if err != nil || math.IsInf(parsed, 0) || math.IsNaN(parsed) {
+		return 0, false
+	}
+
+	return parsed, true
+}
+
+//ResourceFilter decides which resources are reported. Both lists hold names or glob patterns such as nonmem_*. An empty
+//Allow reports everything not denied, and Deny always wins
+type ResourceFilter struct {
+	Allow []string
+	Deny  []string
+}
+
+//Reported indicates whether the named resource passes the filter
+func (f ResourceFilter) Reported(name string) bool {
+	if matchesAny(f.Deny, name) {
+		return false
+	}
+
+	return len(f.Allow) == 0 || matchesAny(f.Allow, name)
+}
+
+func matchesAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		matched, err := path.Match(pattern, name)
+		if err != nil {
+			log.WithError(err).Errorf("Invalid resource pattern %q", pattern)
+			continue
+		}
+
+		if matched {
+			return true
+		}
+	}
+
+	return false
+}
+
+//collectResources emits every numeric resource of the queue instance that passes the collector's filter
+func (collector *GridEngine) collectResources(ch chan<- prometheus.Metric, instance QueueInstance, hostname string, queue string) {
+	for _, r := range instance.Resources {
+		if !collector.Resources.Reported(r.Name) {
+			continue
+		}
+
+		value, ok := ParseResourceValue(r.Value)
+		if !ok {
+			continue
+		}
+
+		ch <- prometheus.MustNewConstMetric(collector.ResourceValue, prometheus.GaugeValue, value, hostname, queue, r.Name, r.Type)
+	}
+}
@@ -0,0 +1,101 @@
+package gridengine_prometheus
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestParseResourceValue(t *testing.T) {
+	tests := []struct {
+		value  string
+		want   float64
+		wantOk bool
+	}{
+		{value: "4", want: 4, wantOk: true},
+		{value: "0.112500", want: 0.1125, wantOk: true},
+		{value: "14.5G", want: 14.5 * 1024 * 1024 * 1024, wantOk: true},
+		{value: "512k", want: 512000, wantOk: true},
+		{value: "2:00:00", want: 7200, wantOk: true},
+		{value: "lx-amd64", wantOk: false},
+		{value: "all.q", wantOk: false},
+		{value: "INFINITY", wantOk: false},
+		{value: "", wantOk: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, ok := ParseResourceValue(tt.value)
+			if ok != tt.wantOk {
+				t.Errorf("ParseResourceValue() ok = %v, want %v", ok, tt.wantOk)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("ParseResourceValue() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResourceFilter_Reported(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter ResourceFilter
+		want   map[string]bool
+	}{
+		{
+			name:   "Everything by default",
+			filter: ResourceFilter{},
+			want:   map[string]bool{"mem_free": true, "nonmem_licenses": true},
+		},
+		{
+			name:   "Allowlist",
+			filter: ResourceFilter{Allow: []string{"nonmem_*", "slots"}},
+			want:   map[string]bool{"mem_free": false, "nonmem_licenses": true, "slots": true},
+		},
+		{
+			name:   "Denylist wins",
+			filter: ResourceFilter{Allow: []string{"mem_*"}, Deny: []string{"mem_used"}},
+			want:   map[string]bool{"mem_free": true, "mem_used": false, "slots": false},
+		},
+		{
+			name:   "Invalid patterns never match",
+			filter: ResourceFilter{Deny: []string{"mem_[", "slots"}},
+			want:   map[string]bool{"mem_free": true, "slots": false},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for resource, want := range tt.want {
+				if got := tt.filter.Reported(resource); got != want {
+					t.Errorf("Reported(%q) = %v, want %v", resource, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestGridEngine_CollectResources(t *testing.T) {
+	collector := NewGridEngine(WithSource(FileSource{Path: "testdata/qstat.xml"}))
+	collector.Resources = ResourceFilter{
+		Allow: []string{"mem_*", "nonmem_*", "slots", "h_rt", "arch"},
+		Deny:  []string{"mem_used"},
+	}
+
+	want := `
+# HELP sge_resource_value Value of a numeric resource from the resource list of the queue instance, by resource and qstat resource type
+# TYPE sge_resource_value gauge
+sge_resource_value{hostname="ip-172-16-2-102.us-west-2.compute.internal",queue="all.q",resource="mem_free",type="hl"} 1.6007343112192e+10
+sge_resource_value{hostname="ip-172-16-2-102.us-west-2.compute.internal",queue="all.q",resource="mem_total",type="hl"} 1.64550934528e+10
+sge_resource_value{hostname="ip-172-16-2-102.us-west-2.compute.internal",queue="all.q",resource="nonmem_licenses",type="gc"} 3
+sge_resource_value{hostname="ip-172-16-2-102.us-west-2.compute.internal",queue="all.q",resource="slots",type="qc"} 2
+sge_resource_value{hostname="ip-172-16-2-251.us-west-2.compute.internal",queue="all.q",resource="mem_free",type="hl"} 1.6119012261888e+10
+sge_resource_value{hostname="ip-172-16-2-251.us-west-2.compute.internal",queue="all.q",resource="mem_total",type="hl"} 1.64550934528e+10
+sge_resource_value{hostname="ip-172-16-2-251.us-west-2.compute.internal",queue="all.q",resource="nonmem_licenses",type="gc"} 3
+sge_resource_value{hostname="ip-172-16-2-251.us-west-2.compute.internal",queue="all.q",resource="slots",type="qc"} 4
+`
+
+	if err := testutil.CollectAndCompare(collector, strings.NewReader(want), "sge_resource_value"); err != nil {
+		t.Errorf("Unexpected resource metrics: %s", err)
+	}
+}
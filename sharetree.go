@@ -0,0 +1,223 @@
+package gridengine_prometheus
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+//ShareNode is a single node of the share tree along with its usage, as reported by sge_share_mon
+type ShareNode struct {
+	//Node is the path of the node within the share tree, such as /modeling/jdoe
+	Node    string
+	User    string
+	Project string
+	//Values are the numeric fields reported for the node, keyed by the sge_share_mon field name
+	Values map[string]float64
+}
+
+//ParseShareMon parses the name=value output of sge_share_mon -n, one node per line
+func ParseShareMon(output string) ([]ShareNode, error) {
+	nodes := make([]ShareNode, 0)
+
+	for i, line := range strings.Split(output, "\n") {
+		if len(strings.TrimSpace(line)) == 0 {
+			continue
+		}
+
+		node := ShareNode{
+			Values: make(map[string]float64),
+		}
+
+		for _, field := range strings.Fields(line) {
+			pieces := strings.SplitN(field, "=", 2)
+			if len(pieces) != 2 {
+				return nil, fmt.Errorf("line %d of the share tree usage has a field without a value: %q", i+1, field)
+			}
+
+			switch pieces[0] {
+			case "node_name":
+				node.Node = pieces[1]
+			case "user_name":
+				node.User = pieces[1]
+			case "project_name":
+				node.Project = pieces[1]
+			default:
+				if value, err := strconv.ParseFloat(pieces[1], 64); err == nil {
+					node.Values[pieces[0]] = value
+				}
+			}
+		}
+
+		if len(node.Node) == 0 {
+			return nil, fmt.Errorf("line %d of the share tree usage has no node_name", i+1)
+		}
+
+		nodes = append(nodes, node)
+	}
+
+	return nodes, nil
+}
+
+//ShareMonPath is the location of sge_share_mon for the provided SGE root and architecture
+func ShareMonPath(root, arch string) string {
+	return filepath.Join(root, "utilbin", arch, "sge_share_mon")
+}
+
+//ShareTreeCollector reports the configured shares and actual usage of every node of the share tree using sge_share_mon
+type ShareTreeCollector struct {
+	Up               *prometheus.Desc
+	Shares           *prometheus.Desc
+	JobCount         *prometheus.Desc
+	Usage            *prometheus.Desc
+	ActualShare      *prometheus.Desc
+	LongTargetShare  *prometheus.Desc
+	ShortTargetShare *prometheus.Desc
+
+	//EffectivePriority isn't reported by sge_share_mon, so is derived from the short and long term target shares
+	EffectivePriority *prometheus.Desc
+
+	//Path is the sge_share_mon binary to run
+	Path string
+
+	//Runner runs sge_share_mon against the grid engine cell. nil runs it in the environment of the exporter
+	Runner *CommandRunner
+
+	//fetch is how we get the raw sge_share_mon output. Swappable for testing
+	fetch func() (string, error)
+}
+
+//NewShareTreeCollector returns a collector that runs the sge_share_mon at path on every collection, with metric names
+//prefixed by namespace
+func NewShareTreeCollector(namespace string, path string) *ShareTreeCollector {
+	nodeLabels := []string{
+		"node",
+		"user",
+		"project",
+	}
+
+	name := func(metric string) string {
+		return prometheus.BuildFQName(namespace, "share_tree", metric)
+	}
+
+	collector := &ShareTreeCollector{
+		Up: prometheus.NewDesc(
+			name("up"),
+			"Whether sge_share_mon was able to run and be parsed (1) or not (0)",
+			nil,
+			nil),
+		Shares: prometheus.NewDesc(
+			name("shares"),
+			"Number of shares configured for the share tree node",
+			nodeLabels,
+			nil),
+		JobCount: prometheus.NewDesc(
+			name("jobs"),
+			"Number of jobs currently counted against the share tree node",
+			nodeLabels,
+			nil),
+		Usage: prometheus.NewDesc(
+			name("usage"),
+			"Decayed combined usage of the share tree node, as weighted by the scheduler configuration",
+			nodeLabels,
+			nil),
+		ActualShare: prometheus.NewDesc(
+			name("actual_share_ratio"),
+			"Proportion of the total usage the share tree node has actually received",
+			nodeLabels,
+			nil),
+		LongTargetShare: prometheus.NewDesc(
+			name("long_target_share_ratio"),
+			"Proportion of the total usage the share tree node is entitled to over the long term",
+			nodeLabels,
+			nil),
+		ShortTargetShare: prometheus.NewDesc(
+			name("short_target_share_ratio"),
+			"Proportion of the total usage the scheduler is currently targeting for the share tree node to correct past usage",
+			nodeLabels,
+			nil),
+		EffectivePriority: prometheus.NewDesc(
+			name("effective_priority"),
+			"Short term over long term target share of the share tree node. Above 1 the scheduler is favouring the node to make up for usage it missed out on, below 1 it is holding the node back for having had more than its share",
+			nodeLabels,
+			nil),
+		Path: path,
+	}
+	collector.fetch = collector.shareMonOutput
+
+	return collector
+}
+
+//Describe provides prometheus with descriptions and details (not values) of each metric
+func (collector *ShareTreeCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- collector.Up
+	ch <- collector.Shares
+	ch <- collector.JobCount
+	ch <- collector.Usage
+	ch <- collector.ActualShare
+	ch <- collector.LongTargetShare
+	ch <- collector.ShortTargetShare
+	ch <- collector.EffectivePriority
+}
+
+//Collect runs sge_share_mon and feeds the shares and usage of each node into the channel
+func (collector *ShareTreeCollector) Collect(ch chan<- prometheus.Metric) {
+	nodes, err := collector.nodes()
+	if err != nil {
+		log.WithError(err).Error("Unable to gather share tree usage from sge_share_mon")
+		ch <- prometheus.MustNewConstMetric(collector.Up, prometheus.GaugeValue, 0)
+		return
+	}
+
+	ch <- prometheus.MustNewConstMetric(collector.Up, prometheus.GaugeValue, 1)
+
+	fields := []struct {
+		field string
+		desc  *prometheus.Desc
+	}{
+		{"shares", collector.Shares},
+		{"job_count", collector.JobCount},
+		{"usage", collector.Usage},
+		{"actual_share", collector.ActualShare},
+		{"long_target_share", collector.LongTargetShare},
+		{"short_target_share", collector.ShortTargetShare},
+	}
+
+	for _, n := range nodes {
+		for _, f := range fields {
+			if value, ok := n.Values[f.field]; ok {
+				ch <- prometheus.MustNewConstMetric(f.desc, prometheus.GaugeValue, value, n.Node, n.User, n.Project)
+			}
+		}
+
+		//A node without any entitlement has no priority to speak of
+		if long := n.Values["long_target_share"]; long > 0 {
+			if short, ok := n.Values["short_target_share"]; ok {
+				ch <- prometheus.MustNewConstMetric(collector.EffectivePriority, prometheus.GaugeValue, short/long, n.Node, n.User, n.Project)
+			}
+		}
+	}
+}
+
+func (collector *ShareTreeCollector) nodes() ([]ShareNode, error) {
+	fetch := collector.fetch
+	if fetch == nil {
+		fetch = collector.shareMonOutput
+	}
+
+	out, err := fetch()
+	if err != nil {
+		return nil, fmt.Errorf("there was an error running sge_share_mon: %w", err)
+	}
+
+	return ParseShareMon(out)
+}
+
+//shareMonOutput takes a single sample (-c 1) in name=value format (-n)
+func (collector *ShareTreeCollector) shareMonOutput() (string, error) {
+	return collector.Runner.Output(collector.Path, "-c", "1", "-n")
+}
@@ -0,0 +1,108 @@
+package gridengine_prometheus
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestParseShareMon(t *testing.T) {
+	tests := []struct {
+		name      string
+		output    string
+		wantNodes int
+		wantErr   bool
+	}{
+		{
+			name:      "Empty",
+			output:    "",
+			wantNodes: 0,
+		},
+		{
+			name:      "Single node",
+			output:    "node_name=/default/jdoe\tuser_name=jdoe\tproject_name=\tshares=10\tusage=12.5\n",
+			wantNodes: 1,
+		},
+		{
+			name:    "Missing node name",
+			output:  "user_name=jdoe\tshares=10\n",
+			wantErr: true,
+		},
+		{
+			name:    "Field without a value",
+			output:  "node_name=/ shares\n",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseShareMon(tt.output)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseShareMon() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if len(got) != tt.wantNodes {
+				t.Errorf("ParseShareMon() returned %d nodes, want %d", len(got), tt.wantNodes)
+			}
+		})
+	}
+}
+
+func TestShareTreeCollector_Collect(t *testing.T) {
+	tests := []struct {
+		name    string
+		fetch   func() (string, error)
+		metrics []string
+		want    string
+	}{
+		{
+			name:    "Share tree usage",
+			fetch:   fixtureFetch(t, "testdata/sge_share_mon.txt"),
+			metrics: []string{"sge_share_tree_up", "sge_share_tree_shares", "sge_share_tree_actual_share_ratio", "sge_share_tree_effective_priority"},
+			want: `
+# HELP sge_share_tree_actual_share_ratio Proportion of the total usage the share tree node has actually received
+# TYPE sge_share_tree_actual_share_ratio gauge
+sge_share_tree_actual_share_ratio{node="/",project="",user=""} 1
+sge_share_tree_actual_share_ratio{node="/default/asmith",project="",user="asmith"} 0.45
+sge_share_tree_actual_share_ratio{node="/modeling",project="modeling",user=""} 0.55
+# HELP sge_share_tree_effective_priority Short term over long term target share of the share tree node. Above 1 the scheduler is favouring the node to make up for usage it missed out on, below 1 it is holding the node back for having had more than its share
+# TYPE sge_share_tree_effective_priority gauge
+sge_share_tree_effective_priority{node="/",project="",user=""} 1
+sge_share_tree_effective_priority{node="/default/asmith",project="",user="asmith"} 0.5
+sge_share_tree_effective_priority{node="/modeling",project="modeling",user=""} 1.2142857142857144
+# HELP sge_share_tree_shares Number of shares configured for the share tree node
+# TYPE sge_share_tree_shares gauge
+sge_share_tree_shares{node="/",project="",user=""} 1
+sge_share_tree_shares{node="/default/asmith",project="",user="asmith"} 30
+sge_share_tree_shares{node="/modeling",project="modeling",user=""} 70
+# HELP sge_share_tree_up Whether sge_share_mon was able to run and be parsed (1) or not (0)
+# TYPE sge_share_tree_up gauge
+sge_share_tree_up 1
+`,
+		},
+		{
+			name: "sge_share_mon failure",
+			fetch: func() (string, error) {
+				return "", errors.New("no share tree")
+			},
+			metrics: []string{"sge_share_tree_up", "sge_share_tree_shares"},
+			want: `
+# HELP sge_share_tree_up Whether sge_share_mon was able to run and be parsed (1) or not (0)
+# TYPE sge_share_tree_up gauge
+sge_share_tree_up 0
+`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			collector := NewShareTreeCollector(DefaultNamespace, "sge_share_mon")
+			collector.fetch = tt.fetch
+
+			if err := testutil.CollectAndCompare(collector, strings.NewReader(tt.want), tt.metrics...); err != nil {
+				t.Errorf("Unexpected share tree metrics: %s", err)
+			}
+		})
+	}
+}
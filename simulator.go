@@ -0,0 +1,416 @@
+package gridengine_prometheus
+
+import (
+	"encoding/xml"
+	"fmt"
+	"math"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+)
+
+//SimulatorConfig is the shape of the synthetic cluster the simulator generates qstat output for. Zero values, other than
+//for FailureRate, are replaced with those from DefaultSimulatorConfig
+type SimulatorConfig struct {
+	//Seed makes the simulation repeatable. The same seed and shape always produces the same sequence of qstat output
+	Seed int64
+	//Hosts is the number of execution hosts, each of which has an instance of every queue
+	Hosts int
+	//Queues are the names of the cluster queues
+	Queues []string
+	//Slots is the number of slots of each queue instance
+	Slots int
+	//ArrivalRate is the average number of jobs submitted each step
+	ArrivalRate float64
+	//FailureRate is the probability of each submitted job ending up in an error state and of each host being
+	//unreachable for a step
+	FailureRate float64
+	//Start is the simulated time of the first step. Defaults to a fixed date so output is repeatable
+	Start time.Time
+	//Step is how much simulated time passes between each qstat run
+	Step time.Duration
+}
+
+//DefaultSimulatorConfig is a small cluster that is kept reasonably busy
+func DefaultSimulatorConfig() SimulatorConfig {
+	return SimulatorConfig{
+		Seed:        1,
+		Hosts:       4,
+		Queues:      []string{"all.q"},
+		Slots:       8,
+		ArrivalRate: 2,
+		FailureRate: 0.02,
+		Start:       time.Date(2020, 1, 1, 0, 0, 0, 0, time.Local),
+		Step:        30 * time.Second,
+	}
+}
+
+//maxSimulatedJobs stops the pending list growing forever when jobs arrive faster than the cluster can run them. Any
+//submitted beyond it are turned away, much like max_jobs on a real cluster
+const maxSimulatedJobs = 1000
+
+//simulatedUsers own the simulated jobs
+var simulatedUsers = []string{"jdoe", "asmith", "bwilson", "cgarcia"}
+
+//hostMemory is the memory of each simulated host in bytes
+const hostMemory = 16 * 1024 * 1024 * 1024
+
+type simulatedJob struct {
+	number   int
+	name     string
+	owner    string
+	priority float64
+	slots    int
+	memory   int64
+	runtime  time.Duration
+	errored  bool
+
+	submitted time.Time
+	started   time.Time
+	//instance is the queue instance the job is running on, or empty while it is pending
+	instance string
+}
+
+type simulatedHost struct {
+	name string
+	down bool
+}
+
+//Simulator generates realistic, evolving qstat XML for a synthetic cluster without needing a grid engine. Each fetch
+//moves the simulation on a step: finished jobs leave, new jobs are submitted and pending jobs are scheduled onto free
+//slots
+type Simulator struct {
+	Config SimulatorConfig
+
+	mutex   sync.Mutex
+	random  *rand.Rand
+	now     time.Time
+	hosts   []simulatedHost
+	jobs    []*simulatedJob
+	nextJob int
+	steps   int
+}
+
+//NewSimulator returns a simulator for the cluster shape in config
+func NewSimulator(config SimulatorConfig) *Simulator {
+	defaults := DefaultSimulatorConfig()
+
+	if config.Seed == 0 {
+		config.Seed = defaults.Seed
+	}
+	if config.Hosts <= 0 {
+		config.Hosts = defaults.Hosts
+	}
+	if len(config.Queues) == 0 {
+		config.Queues = defaults.Queues
+	}
+	if config.Slots <= 0 {
+		config.Slots = defaults.Slots
+	}
+	if config.ArrivalRate <= 0 {
+		config.ArrivalRate = defaults.ArrivalRate
+	}
+	if config.FailureRate < 0 {
+		config.FailureRate = 0
+	}
+	if config.Start.IsZero() {
+		config.Start = defaults.Start
+	}
+	if config.Step <= 0 {
+		config.Step = defaults.Step
+	}
+
+	s := &Simulator{
+		Config:  config,
+		random:  rand.New(rand.NewSource(config.Seed)),
+		now:     config.Start,
+		nextJob: 1,
+	}
+
+	for i := 0; i < config.Hosts; i++ {
+		s.hosts = append(s.hosts, simulatedHost{
+			name: fmt.Sprintf("node%03d.sim.local", i+1),
+		})
+	}
+
+	return s
+}
+
+//Fetch moves the simulation on a step and returns the qstat XML for the cluster as it now stands
+func (s *Simulator) Fetch() (string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.step()
+
+	return s.render(), nil
+}
+
+func (s *Simulator) step() {
+	if s.steps > 0 {
+		s.now = s.now.Add(s.Config.Step)
+	}
+	s.steps++
+
+	//Finished jobs leave, as do errored jobs once someone gets round to deleting them
+	remaining := make([]*simulatedJob, 0, len(s.jobs))
+	for _, j := range s.jobs {
+		finished := len(j.instance) > 0 && !s.now.Before(j.started.Add(j.runtime))
+		deleted := j.errored && s.random.Float64() < 0.1
+
+		if !finished && !deleted {
+			remaining = append(remaining, j)
+		}
+	}
+	s.jobs = remaining
+
+	for i := range s.hosts {
+		s.hosts[i].down = s.random.Float64() < s.Config.FailureRate
+	}
+
+	for i := poisson(s.random, s.Config.ArrivalRate); i > 0 && len(s.jobs) < maxSimulatedJobs; i-- {
+		s.submit()
+	}
+
+	s.schedule()
+}
+
+func (s *Simulator) submit() {
+	slots := 1
+	if s.random.Float64() < 0.2 {
+		slots = 1 + s.random.Intn(s.Config.Slots)
+	}
+
+	j := &simulatedJob{
+		number:    s.nextJob,
+		name:      fmt.Sprintf("sim%d", s.nextJob),
+		owner:     simulatedUsers[s.random.Intn(len(simulatedUsers))],
+		priority:  0.5 + s.random.Float64()/10,
+		slots:     slots,
+		memory:    int64(1+s.random.Intn(8)) * 1024 * 1024 * 1024,
+		runtime:   time.Duration(1+s.random.Intn(20)) * s.Config.Step,
+		errored:   s.random.Float64() < s.Config.FailureRate,
+		submitted: s.now,
+	}
+
+	s.nextJob++
+	s.jobs = append(s.jobs, j)
+}
+
+//schedule starts pending jobs, highest priority first, on the first queue instance with enough free slots
+func (s *Simulator) schedule() {
+	used := s.usedSlots()
+
+	for _, j := range s.pendingByPriority() {
+		for _, instance := range s.instances() {
+			if s.instanceDown(instance) || used[instance]+j.slots > s.Config.Slots {
+				continue
+			}
+
+			j.instance = instance
+			j.started = s.now
+			used[instance] += j.slots
+			break
+		}
+	}
+}
+
+func (s *Simulator) pendingByPriority() []*simulatedJob {
+	pending := make([]*simulatedJob, 0)
+	for _, j := range s.jobs {
+		if len(j.instance) == 0 && !j.errored {
+			pending = append(pending, j)
+		}
+	}
+
+	//A stable insertion sort keeps jobs of the same priority in submission order
+	for i := 1; i < len(pending); i++ {
+		for k := i; k > 0 && pending[k].priority > pending[k-1].priority; k-- {
+			pending[k], pending[k-1] = pending[k-1], pending[k]
+		}
+	}
+
+	return pending
+}
+
+func (s *Simulator) instances() []string {
+	instances := make([]string, 0, len(s.Config.Queues)*len(s.hosts))
+	for _, q := range s.Config.Queues {
+		for _, h := range s.hosts {
+			instances = append(instances, q+"@"+h.name)
+		}
+	}
+	return instances
+}
+
+func (s *Simulator) instanceDown(instance string) bool {
+	for _, h := range s.hosts {
+		if strings.HasSuffix(instance, "@"+h.name) {
+			return h.down
+		}
+	}
+	return false
+}
+
+func (s *Simulator) usedSlots() map[string]int {
+	used := make(map[string]int)
+	for _, j := range s.jobs {
+		if len(j.instance) > 0 {
+			used[j.instance] += j.slots
+		}
+	}
+	return used
+}
+
+//qstatTime formats the time as qstat does, in local time
+func qstatTime(t time.Time) string {
+	return t.In(time.Local).Format(qstatTimeLayouts[0])
+}
+
+//gigabytes formats a number of bytes as qstat does for memory values
+func gigabytes(bytes int64) string {
+	return fmt.Sprintf("%.3fG", float64(bytes)/(1024*1024*1024))
+}
+
+func (s *Simulator) render() string {
+	b := &strings.Builder{}
+	used := s.usedSlots()
+
+	b.WriteString("<?xml version='1.0'?>\n<job_info>\n  <queue_info>\n")
+
+	for _, q := range s.Config.Queues {
+		for _, h := range s.hosts {
+			instance := q + "@" + h.name
+
+			//Load and memory follow the jobs running on the host across every queue
+			hostSlots, hostMemoryUsed := 0, int64(0)
+			for _, j := range s.jobs {
+				if strings.HasSuffix(j.instance, "@"+h.name) {
+					hostSlots += j.slots
+					hostMemoryUsed += j.memory / 2
+				}
+			}
+			if hostMemoryUsed > hostMemory {
+				hostMemoryUsed = hostMemory
+			}
+
+			cores := float64(s.Config.Slots)
+			load := float64(hostSlots) + s.random.Float64()/4
+			cpu := math.Min(100, 100*float64(hostSlots)/cores)
+
+			b.WriteString("    <Queue-List>\n")
+			writeElement(b, 6, "name", instance)
+			writeElement(b, 6, "qtype", "BIP")
+			writeElement(b, 6, "slots_used", fmt.Sprint(used[instance]))
+			writeElement(b, 6, "slots_resv", "0")
+			writeElement(b, 6, "slots_total", fmt.Sprint(s.Config.Slots))
+			writeElement(b, 6, "arch", "lx-amd64")
+
+			if h.down {
+				//qstat has no load values for a host it can't reach
+				writeElement(b, 6, "state", "au")
+			} else {
+				writeElement(b, 6, "load_avg", fmt.Sprintf("%.5f", load))
+				writeResource(b, "load_avg", "hl", fmt.Sprintf("%.6f", load))
+				writeResource(b, "np_load_avg", "hl", fmt.Sprintf("%.6f", load/cores))
+				writeResource(b, "num_proc", "hl", fmt.Sprint(s.Config.Slots))
+				writeResource(b, "mem_total", "hl", gigabytes(hostMemory))
+				writeResource(b, "mem_used", "hl", gigabytes(hostMemoryUsed))
+				writeResource(b, "mem_free", "hl", gigabytes(hostMemory-hostMemoryUsed))
+				writeResource(b, "cpu", "hl", fmt.Sprintf("%.6f", cpu))
+			}
+
+			writeResource(b, "arch", "hl", "lx-amd64")
+			writeResource(b, "qname", "qf", q)
+			writeResource(b, "hostname", "qf", h.name)
+			writeResource(b, "slots", "qc", fmt.Sprint(s.Config.Slots-used[instance]))
+
+			for _, j := range s.jobs {
+				if j.instance == instance {
+					writeJob(b, j)
+				}
+			}
+
+			b.WriteString("    </Queue-List>\n")
+		}
+	}
+
+	b.WriteString("  </queue_info>\n  <job_info>\n")
+
+	for _, j := range s.jobs {
+		if len(j.instance) == 0 {
+			writeJob(b, j)
+		}
+	}
+
+	b.WriteString("  </job_info>\n</job_info>\n")
+
+	return b.String()
+}
+
+func writeJob(b *strings.Builder, j *simulatedJob) {
+	indent := 6
+	state, listState := "qw", "pending"
+	if j.errored {
+		state = "Eqw"
+	}
+	if len(j.instance) > 0 {
+		indent = 8
+		state, listState = "r", "running"
+	}
+
+	pad := strings.Repeat(" ", indent-2)
+	fmt.Fprintf(b, "%s<job_list state=\"%s\">\n", pad, listState)
+	writeElement(b, indent, "JB_job_number", fmt.Sprint(j.number))
+	writeElement(b, indent, "JAT_prio", fmt.Sprintf("%.5f", j.priority))
+	writeElement(b, indent, "JB_name", j.name)
+	writeElement(b, indent, "JB_owner", j.owner)
+	writeElement(b, indent, "state", state)
+
+	if len(j.instance) > 0 {
+		writeElement(b, indent, "JAT_start_time", qstatTime(j.started))
+	} else {
+		writeElement(b, indent, "JB_submission_time", qstatTime(j.submitted))
+	}
+
+	writeElement(b, indent, "slots", fmt.Sprint(j.slots))
+
+	if j.slots > 1 {
+		fmt.Fprintf(b, "%s<requested_pe name=\"smp\">%d</requested_pe>\n", strings.Repeat(" ", indent), j.slots)
+	}
+
+	fmt.Fprintf(b, "%s<hard_request name=\"h_vmem\" resource_contribution=\"0.000000\">%dG</hard_request>\n",
+		strings.Repeat(" ", indent), j.memory/(1024*1024*1024))
+	fmt.Fprintf(b, "%s<hard_request name=\"h_rt\" resource_contribution=\"0.000000\">%d</hard_request>\n",
+		strings.Repeat(" ", indent), int(j.runtime.Seconds()))
+
+	fmt.Fprintf(b, "%s</job_list>\n", pad)
+}
+
+func writeElement(b *strings.Builder, indent int, name string, value string) {
+	fmt.Fprintf(b, "%s<%s>", strings.Repeat(" ", indent), name)
+	_ = xml.EscapeText(b, []byte(value))
+	fmt.Fprintf(b, "</%s>\n", name)
+}
+
+func writeResource(b *strings.Builder, name string, kind string, value string) {
+	fmt.Fprintf(b, "      <resource name=\"%s\" type=\"%s\">", name, kind)
+	_ = xml.EscapeText(b, []byte(value))
+	b.WriteString("</resource>\n")
+}
+
+//poisson draws the number of events in an interval with the provided mean, using Knuth's method as the means involved
+//are small
+func poisson(random *rand.Rand, mean float64) int {
+	limit := math.Exp(-mean)
+	product := random.Float64()
+
+	count := 0
+	for product > limit {
+		count++
+		product *= random.Float64()
+	}
+
+	return count
+}
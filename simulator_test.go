@@ -0,0 +1,114 @@
+package gridengine_prometheus
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func simulate(t *testing.T, config SimulatorConfig, steps int) []string {
+	simulator := NewSimulator(config)
+
+	out := make([]string, 0, steps)
+	for i := 0; i < steps; i++ {
+		x, err := simulator.Fetch()
+		if err != nil {
+			t.Fatal(err)
+		}
+		out = append(out, x)
+	}
+
+	return out
+}
+
+func TestSimulator_Deterministic(t *testing.T) {
+	config := DefaultSimulatorConfig()
+
+	first := simulate(t, config, 20)
+	second := simulate(t, config, 20)
+
+	for i := range first {
+		if first[i] != second[i] {
+			t.Fatalf("Step %d differs between two simulations with the same seed", i+1)
+		}
+	}
+
+	config.Seed = 2
+	other := simulate(t, config, 20)
+
+	if strings.Join(first, "") == strings.Join(other, "") {
+		t.Error("Expected a different seed to produce a different simulation")
+	}
+}
+
+func TestSimulator_Evolves(t *testing.T) {
+	config := SimulatorConfig{
+		Seed:        7,
+		Hosts:       3,
+		Queues:      []string{"all.q", "gpu.q"},
+		Slots:       4,
+		ArrivalRate: 3,
+		FailureRate: 0.1,
+	}
+
+	simulator := NewSimulator(config)
+	seen := make(map[string]bool)
+	var last time.Time
+
+	for i := 0; i < 50; i++ {
+		snapshot, err := takeSnapshot(simulator, func(*Snapshot) QstatVisitor {
+			return QstatVisitor{
+				Queue: func(q QueueInstance) {
+					if q.SlotsUsed > q.SlotsTotal {
+						t.Errorf("Step %d: %s uses %d of %d slots", i+1, q.Name, q.SlotsUsed, q.SlotsTotal)
+					}
+
+					for _, j := range q.Jobs {
+						seen[j.State] = true
+					}
+				},
+				PendingJob: func(j Job) {
+					seen[j.State] = true
+				},
+			}
+		})
+		if err != nil {
+			t.Fatalf("Step %d: unable to parse the simulated qstat output: %s", i+1, err)
+		}
+
+		if len(snapshot.Queues) != 6 {
+			t.Fatalf("Step %d: expected an instance of each queue on each host, got %d", i+1, len(snapshot.Queues))
+		}
+
+		if !simulator.now.After(last) {
+			t.Errorf("Step %d: expected simulated time to move on from %s", i+1, last)
+		}
+		last = simulator.now
+	}
+
+	for _, state := range []string{"r", "qw", "Eqw"} {
+		if !seen[state] {
+			t.Errorf("Expected jobs in state %s at some point in the simulation", state)
+		}
+	}
+}
+
+func TestSimulator_Collect(t *testing.T) {
+	collector := NewGridEngine(WithSource(NewSimulator(SimulatorConfig{Seed: 3, Hosts: 2, Slots: 4})))
+
+	want := `
+# HELP sge_queue_slots Total Number of slots available to the host
+# TYPE sge_queue_slots gauge
+sge_queue_slots{hostname="node001.sim.local",queue="all.q"} 4
+sge_queue_slots{hostname="node002.sim.local",queue="all.q"} 4
+# HELP sge_up Whether the most recent attempt to gather qstat details succeeded (1) or not (0)
+# TYPE sge_up gauge
+sge_up 1
+`
+
+	if err := testutil.CollectAndCompare(collector, strings.NewReader(want), "sge_queue_slots", "sge_up"); err != nil {
+		t.Errorf("Unexpected metrics from the simulated cluster: %s", err)
+	}
+}
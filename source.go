@@ -0,0 +1,57 @@
+package gridengine_prometheus
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+//Source supplies the raw qstat XML the grid engine metrics are built from. QstatSource runs qstat itself, while
+//FileSource, Replay and Simulator stand in for it when there is no grid engine to talk to
+type Source interface {
+	Fetch() (string, error)
+}
+
+//SourceFunc adapts an ordinary function into a Source
+type SourceFunc func() (string, error)
+
+//Fetch calls the function
+func (f SourceFunc) Fetch() (string, error) {
+	return f()
+}
+
+//QstatArgs are the arguments qstat is run with. gogridengine doesn't ask for the resource requests of each job (-r),
+//so we run qstat ourselves
+var QstatArgs = []string{"-u", "*", "-F", "-r", "-xml"}
+
+//QstatSource runs qstat for every job and queue instance on the cluster
+type QstatSource struct {
+	Cluster Cluster
+}
+
+//Fetch runs qstat and returns its XML output
+func (s QstatSource) Fetch() (string, error) {
+	return s.Cluster.Output("qstat", QstatArgs...)
+}
+
+//Stream runs qstat and returns its XML output as it is written
+func (s QstatSource) Stream() (io.ReadCloser, error) {
+	return s.Cluster.Stream("qstat", QstatArgs...)
+}
+
+//FileSource reads qstat XML saved to a file, reading it again on every fetch so it can be changed underneath a running
+//exporter
+type FileSource struct {
+	Path string
+}
+
+//Fetch reads the file
+func (s FileSource) Fetch() (string, error) {
+	out, err := ioutil.ReadFile(s.Path)
+	return string(out), err
+}
+
+//Stream opens the file to be read as it is decoded
+func (s FileSource) Stream() (io.ReadCloser, error) {
+	return os.Open(s.Path)
+}
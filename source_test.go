@@ -0,0 +1,48 @@
+package gridengine_prometheus
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestFileSource_Fetch(t *testing.T) {
+	tests := []struct {
+		name    string
+		path    string
+		wantErr bool
+	}{
+		{
+			name: "Saved payload",
+			path: "testdata/qstat.xml",
+		},
+		{
+			name:    "Missing file",
+			path:    "testdata/missing.xml",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := FileSource{Path: tt.path}.Fetch()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Fetch() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if !tt.wantErr && len(out) == 0 {
+				t.Errorf("Fetch() returned an empty payload")
+			}
+		})
+	}
+}
+
+func TestSourceFunc_Fetch(t *testing.T) {
+	failure := errors.New("qstat: command not found")
+
+	out, err := SourceFunc(func() (string, error) {
+		return "<job_info/>", failure
+	}).Fetch()
+
+	if out != "<job_info/>" || err != failure {
+		t.Errorf("Fetch() = %q, %v", out, err)
+	}
+}
@@ -0,0 +1,115 @@
+package gridengine_prometheus
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+)
+
+//StreamSource is a Source that can also hand over the qstat XML as it is read, so the whole payload never has to be
+//held in memory at once
+type StreamSource interface {
+	Source
+	//Stream returns the qstat XML. Any failure to produce it, such as qstat exiting with an error, is returned by Close
+	Stream() (io.ReadCloser, error)
+}
+
+//openStream streams the XML from the source if it can, and otherwise fetches it in one go
+func openStream(source Source) (io.ReadCloser, error) {
+	if s, ok := source.(StreamSource); ok {
+		return s.Stream()
+	}
+
+	out, err := source.Fetch()
+	if err != nil {
+		return nil, err
+	}
+
+	return io.NopCloser(strings.NewReader(out)), nil
+}
+
+//QstatVisitor is handed each queue instance, along with the jobs running in it, and each pending job as soon as it has
+//been decoded
+type QstatVisitor struct {
+	Queue      func(instance QueueInstance)
+	PendingJob func(job Job)
+}
+
+//DecodeQstat reads qstat XML a queue instance or pending job at a time, handing each to the visitor as soon as it has
+//been read. Only the element being decoded is held in memory, however large the document, and each is decoded once
+func DecodeQstat(r io.Reader, visitor QstatVisitor) error {
+	decoder := xml.NewDecoder(r)
+
+	//path holds the names of the elements we are currently inside of
+	path := make([]string, 0, 4)
+	root := false
+
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			if len(path) == 0 {
+				if root || t.Name.Local != "job_info" {
+					return fmt.Errorf("expected a single element type <job_info> but have <%s>", t.Name.Local)
+				}
+				root = true
+			}
+
+			switch {
+			case matchPath(path, "job_info", "queue_info") && t.Name.Local == "Queue-List":
+				instance := QueueInstance{}
+
+				if err := decoder.DecodeElement(&instance, &t); err != nil {
+					return err
+				}
+
+				if visitor.Queue != nil {
+					visitor.Queue(instance)
+				}
+			case matchPath(path, "job_info", "job_info") && t.Name.Local == "job_list":
+				job := Job{}
+
+				if err := decoder.DecodeElement(&job, &t); err != nil {
+					return err
+				}
+
+				if visitor.PendingJob != nil {
+					visitor.PendingJob(job)
+				}
+			default:
+				path = append(path, t.Name.Local)
+			}
+		case xml.EndElement:
+			path = path[:len(path)-1]
+		}
+	}
+
+	if !root || len(path) > 0 {
+		return io.ErrUnexpectedEOF
+	}
+
+	return nil
+}
+
+//matchPath checks whether the path of elements is exactly the names provided
+func matchPath(path []string, names ...string) bool {
+	if len(path) != len(names) {
+		return false
+	}
+
+	for i := range path {
+		if path[i] != names[i] {
+			return false
+		}
+	}
+
+	return true
+}
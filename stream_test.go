@@ -0,0 +1,374 @@
+package gridengine_prometheus
+
+import (
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"runtime/metrics"
+	"strings"
+	"testing"
+	"time"
+)
+
+//qstatDocument is the whole of the qstat XML, as it was unmarshalled before streaming
+type qstatDocument struct {
+	XMLName     xml.Name        `xml:"job_info"`
+	Queues      []QueueInstance `xml:"queue_info>Queue-List"`
+	PendingJobs []Job           `xml:"job_info>job_list"`
+}
+
+//unmarshalQstat decodes qstat XML the way it was before streaming, unmarshalling the whole document at once
+func unmarshalQstat(x string) (qstatDocument, error) {
+	document := qstatDocument{}
+	err := xml.Unmarshal([]byte(x), &document)
+	return document, err
+}
+
+func TestDecodeQstat(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		wantErr bool
+	}{
+		{
+			name:    "Fixture",
+			content: readFixture(t, "testdata/qstat.xml"),
+		},
+		{
+			name:    "Generated",
+			content: generateQstat(t, 3, 4, 5),
+		},
+		{
+			name:    "Self closing elements",
+			content: "<job_info><queue_info><Queue-List/></queue_info><job_info><job_list state=\"pending\"/></job_info></job_info>",
+		},
+		{
+			name:    "No jobs",
+			content: "<?xml version='1.0'?><job_info><queue_info></queue_info><job_info></job_info></job_info>",
+		},
+		{
+			name:    "Truncated",
+			content: "<job_info><queue_info>",
+			wantErr: true,
+		},
+		{
+			name:    "Truncated inside a queue instance",
+			content: "<job_info><queue_info><Queue-List><name>all.q@vm</name>",
+			wantErr: true,
+		},
+		{
+			name:    "Wrong document",
+			content: "<qhost></qhost>",
+			wantErr: true,
+		},
+		{
+			name:    "Empty",
+			content: "",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := qstatDocument{}
+
+			err := DecodeQstat(strings.NewReader(tt.content), QstatVisitor{
+				Queue: func(instance QueueInstance) {
+					got.Queues = append(got.Queues, instance)
+				},
+				PendingJob: func(job Job) {
+					got.PendingJobs = append(got.PendingJobs, job)
+				},
+			})
+
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("DecodeQstat() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			//Streaming has to decode exactly what unmarshalling the whole document does
+			want, err := unmarshalQstat(tt.content)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want.XMLName = xml.Name{}
+
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("DecodeQstat() = %+v, want %+v", got, want)
+			}
+		})
+	}
+}
+
+func readFixture(t testing.TB, path string) string {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Unable to read fixture %s: %s", path, err)
+	}
+	return string(content)
+}
+
+//writeQstat writes qstat XML for a cluster of queue instances each running jobsPerQueue jobs, with pending jobs waiting
+func writeQstat(w io.Writer, queues int, jobsPerQueue int, pending int) {
+	job := func(number int, state string, queue string) {
+		fmt.Fprintf(w, "    <job_list state=\"%s\">\n", map[string]string{"r": "running", "qw": "pending"}[state])
+		fmt.Fprintf(w, "      <JB_job_number>%d</JB_job_number>\n", number)
+		fmt.Fprintf(w, "      <JAT_prio>0.%05d</JAT_prio>\n", number%100000)
+		fmt.Fprintf(w, "      <JB_name>job%d</JB_name>\n", number)
+		fmt.Fprintf(w, "      <JB_owner>user%d</JB_owner>\n", number%20)
+		fmt.Fprintf(w, "      <state>%s</state>\n", state)
+		if state == "r" {
+			fmt.Fprintf(w, "      <JAT_start_time>2020-01-01T00:00:00</JAT_start_time>\n")
+		} else {
+			fmt.Fprintf(w, "      <JB_submission_time>2020-01-01T00:00:00</JB_submission_time>\n")
+			fmt.Fprintf(w, "      <hard_req_queue>%s</hard_req_queue>\n", queue)
+		}
+		fmt.Fprintf(w, "      <slots>1</slots>\n")
+		fmt.Fprintf(w, "      <hard_request name=\"h_vmem\" resource_contribution=\"0.000000\">4G</hard_request>\n")
+		fmt.Fprintf(w, "      <hard_request name=\"h_rt\" resource_contribution=\"0.000000\">1:00:00</hard_request>\n")
+		fmt.Fprintf(w, "    </job_list>\n")
+	}
+
+	fmt.Fprintf(w, "<?xml version='1.0'?>\n<job_info>\n  <queue_info>\n")
+
+	number := 1
+	for q := 0; q < queues; q++ {
+		fmt.Fprintf(w, "  <Queue-List>\n")
+		fmt.Fprintf(w, "    <name>all.q@node%05d.cluster.local</name>\n", q)
+		fmt.Fprintf(w, "    <qtype>BIP</qtype>\n")
+		fmt.Fprintf(w, "    <slots_used>%d</slots_used>\n", jobsPerQueue)
+		fmt.Fprintf(w, "    <slots_resv>0</slots_resv>\n")
+		fmt.Fprintf(w, "    <slots_total>%d</slots_total>\n", jobsPerQueue)
+		fmt.Fprintf(w, "    <load_avg>0.50000</load_avg>\n")
+		for _, r := range []string{`"load_avg" type="hl">0.5`, `"mem_free" type="hl">14.908G`, `"mem_total" type="hl">15.325G`, `"mem_used" type="hl">428.000M`, `"cpu" type="hl">11.2`, `"num_proc" type="hl">4`, `"h_vmem" type="qf">infinity`} {
+			fmt.Fprintf(w, "    <resource name=%s</resource>\n", r)
+		}
+		for j := 0; j < jobsPerQueue; j++ {
+			job(number, "r", "all.q")
+			number++
+		}
+		fmt.Fprintf(w, "  </Queue-List>\n")
+	}
+
+	fmt.Fprintf(w, "  </queue_info>\n  <job_info>\n")
+	for p := 0; p < pending; p++ {
+		job(number, "qw", "all.q")
+		number++
+	}
+	fmt.Fprintf(w, "  </job_info>\n</job_info>\n")
+}
+
+func generateQstat(t testing.TB, queues int, jobsPerQueue int, pending int) string {
+	builder := &strings.Builder{}
+	writeQstat(builder, queues, jobsPerQueue, pending)
+	return builder.String()
+}
+
+//peakHeap samples the size of the heap while run is running, returning the largest it got. B/op only counts what was
+//allocated, not how much of it had to be held at once
+func peakHeap(run func()) float64 {
+	samples := []metrics.Sample{{Name: "/memory/classes/heap/objects:bytes"}}
+
+	runtime.GC()
+	metrics.Read(samples)
+	base := samples[0].Value.Uint64()
+	peak := base
+
+	done := make(chan struct{})
+	sampled := make(chan struct{})
+
+	go func() {
+		defer close(sampled)
+
+		ticker := time.NewTicker(100 * time.Microsecond)
+		defer ticker.Stop()
+
+		for {
+			metrics.Read(samples)
+			if size := samples[0].Value.Uint64(); size > peak {
+				peak = size
+			}
+
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	run()
+	close(done)
+	<-sampled
+
+	return float64(peak - base)
+}
+
+//BenchmarkTakeSnapshot compares decoding qstat XML as it is read against reading it whole and unmarshalling it, for
+//clusters of increasing size. Both summarize the jobs as the poller does, with per job series unlimited, capped and
+//disabled. peak-heap-B is the most the heap grew by while taking a snapshot
+func BenchmarkTakeSnapshot(b *testing.B) {
+	sizes := []struct {
+		queues  int
+		jobs    int
+		pending int
+	}{
+		{queues: 100, jobs: 8, pending: 200},
+		{queues: 1000, jobs: 16, pending: 4000},
+		{queues: 1000, jobs: 32, pending: 18000},
+	}
+
+	series := []struct {
+		name      string
+		configure func(collector *GridEngine)
+	}{
+		{name: "Unlimited", configure: func(collector *GridEngine) {}},
+		{name: "Capped", configure: func(collector *GridEngine) { collector.MaxJobSeries = 100 }},
+		{name: "Disabled", configure: func(collector *GridEngine) { collector.DisableJobSeries = true }},
+	}
+
+	for _, size := range sizes {
+		path := filepath.Join(b.TempDir(), "qstat.xml")
+
+		file, err := os.Create(path)
+		if err != nil {
+			b.Fatal(err)
+		}
+		buffered := bufio.NewWriter(file)
+		writeQstat(buffered, size.queues, size.jobs, size.pending)
+		buffered.Flush()
+		file.Close()
+
+		tasks := size.queues*size.jobs + size.pending
+
+		//piped writes the file out at a steady rate, as qstat does while it works through a large cluster, so the time
+		//spent waiting on qstat shows up in the benchmark
+		piped := pipedSource{path: path, rate: 16 << 20}
+
+		for _, s := range series {
+			for _, approach := range []struct {
+				name   string
+				source Source
+				run    func(b *testing.B, collector *GridEngine, source Source)
+			}{
+				{name: "Unmarshal/File", source: FileSource{Path: path}, run: unmarshal},
+				{name: "Stream/File", source: FileSource{Path: path}, run: stream},
+				{name: "Unmarshal/Piped", source: piped, run: unmarshal},
+				{name: "Stream/Piped", source: piped, run: stream},
+			} {
+				b.Run(fmt.Sprintf("%s/%s/%d", approach.name, s.name, tasks), func(b *testing.B) {
+					collector := NewGridEngine(WithSource(approach.source))
+					s.configure(collector)
+
+					run := func() {
+						approach.run(b, collector, approach.source)
+					}
+
+					b.ReportAllocs()
+					peak := peakHeap(run)
+					b.ResetTimer()
+
+					for i := 0; i < b.N; i++ {
+						run()
+					}
+
+					b.ReportMetric(peak, "peak-heap-B")
+				})
+			}
+		}
+	}
+}
+
+//unmarshal takes a snapshot the way it was taken before streaming, reading and decoding the whole payload before the
+//jobs are summarized
+func unmarshal(b *testing.B, collector *GridEngine, source Source) {
+	out, err := source.Fetch()
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	document, err := unmarshalQstat(out)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	visitor := collector.summarize(&Snapshot{Timestamp: time.Now()})
+
+	for _, instance := range document.Queues {
+		visitor.Queue(instance)
+	}
+
+	for _, job := range document.PendingJobs {
+		visitor.PendingJob(job)
+	}
+}
+
+//stream takes a snapshot as the poller does, summarizing the jobs as they are decoded
+func stream(b *testing.B, collector *GridEngine, source Source) {
+	if _, err := takeSnapshot(source, collector.summarize); err != nil {
+		b.Fatal(err)
+	}
+}
+
+//pipedSource streams a file through a pipe at no more than rate bytes a second
+type pipedSource struct {
+	path string
+	rate int
+}
+
+func (s pipedSource) Fetch() (string, error) {
+	stream, err := s.Stream()
+	if err != nil {
+		return "", err
+	}
+	defer stream.Close()
+
+	out, err := io.ReadAll(stream)
+	return string(out), err
+}
+
+func (s pipedSource) Stream() (io.ReadCloser, error) {
+	file, err := os.Open(s.path)
+	if err != nil {
+		return nil, err
+	}
+
+	reader, writer := io.Pipe()
+
+	go func() {
+		defer file.Close()
+
+		chunk := make([]byte, 64*1024)
+		interval := time.Duration(len(chunk)) * time.Second / time.Duration(s.rate)
+		next := time.Now()
+
+		for {
+			n, err := file.Read(chunk)
+			if n > 0 {
+				next = next.Add(interval)
+				time.Sleep(time.Until(next))
+
+				if _, err := writer.Write(chunk[:n]); err != nil {
+					return
+				}
+			}
+			if err == io.EOF {
+				writer.Close()
+				return
+			}
+			if err != nil {
+				writer.CloseWithError(err)
+				return
+			}
+		}
+	}()
+
+	return reader, nil
+}
@@ -0,0 +1,71 @@
+package gridengine_prometheus
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+//sizeMultipliers follows the SGE convention of upper case suffixes being powers of 1024 and lower case powers of 1000
+var sizeMultipliers = map[byte]float64{
+	'K': 1 << 10,
+	'M': 1 << 20,
+	'G': 1 << 30,
+	'T': 1 << 40,
+	'k': 1e3,
+	'm': 1e6,
+	'g': 1e9,
+	't': 1e12,
+}
+
+//ParseSize converts an SGE memory value such as 15.325G or 428.000M into bytes
+func ParseSize(value string) (float64, error) {
+	value = strings.TrimSpace(value)
+
+	if len(value) == 0 {
+		return 0, fmt.Errorf("empty size value")
+	}
+
+	multiplier := 1.0
+	if m, ok := sizeMultipliers[value[len(value)-1]]; ok {
+		multiplier = m
+		value = value[:len(value)-1]
+	}
+
+	parsed, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, fmt.Errorf("unable to parse size %s: %w", value, err)
+	}
+
+	return parsed * multiplier, nil
+}
+
+//ParseTime converts an SGE time value, either a number of seconds or [[hours:]minutes:]seconds, into seconds.
+//INFINITY is returned as +Inf
+func ParseTime(value string) (float64, error) {
+	value = strings.TrimSpace(value)
+
+	if len(value) == 0 {
+		return 0, fmt.Errorf("empty time value")
+	}
+
+	pieces := strings.Split(value, ":")
+	if len(pieces) > 3 {
+		return 0, fmt.Errorf("unable to parse time %s: too many fields", value)
+	}
+
+	seconds := 0.0
+	for _, p := range pieces {
+		//Empty fields are allowed and count as 0, so ::30 is 30 seconds
+		parsed := 0.0
+		if len(p) > 0 {
+			var err error
+			if parsed, err = strconv.ParseFloat(p, 64); err != nil {
+				return 0, fmt.Errorf("unable to parse time %s: %w", value, err)
+			}
+		}
+		seconds = seconds*60 + parsed
+	}
+
+	return seconds, nil
+}